@@ -0,0 +1,523 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Root - where the cgroup v2 hierarchy is usually mounted
+const Root = "/sys/fs/cgroup"
+
+// Leaf - the sub-group drinit moves itself into, a group with processes
+// of its own cannot enable controllers for its sub-groups
+const Leaf = "drinit"
+
+// Controllers - the controllers enabled for sub-groups when available
+var Controllers = []string{"cpu", "memory", "pids", "io"}
+
+const _CGROUP2_SUPER_MAGIC = 0x63677270
+
+// IsV2 - dir is on a cgroup v2 filesystem
+func IsV2(dir string) bool {
+	var fs syscall.Statfs_t
+	if e := syscall.Statfs(dir, &fs); e != nil {
+		return false
+	}
+	return fs.Type == _CGROUP2_SUPER_MAGIC
+}
+
+// Mount - the cgroup v2 mount point, Root or the unified mount of a hybrid
+// hierarchy, empty if cgroup v2 is not mounted
+func Mount() string {
+	if IsV2(Root) {
+		return Root
+	}
+	f, e := os.Open("/proc/self/mounts")
+	if e != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 2 && fields[2] == "cgroup2" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// Self - the cgroup v2 directory of drinit
+func Self() (string, error) {
+	mnt := Mount()
+	if len(mnt) == 0 {
+		return "", errors.New("cgroup v2 is not mounted")
+	}
+	b, e := ioutil.ReadFile("/proc/self/cgroup")
+	if e != nil {
+		return "", e
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(l, "0::") {
+			return filepath.Join(mnt, strings.TrimPrefix(l, "0::")), nil
+		}
+	}
+	return "", errors.New("drinit is not in a cgroup v2 group")
+}
+
+// Delegated - the group delegated to drinit, the parent of drinit's group
+// when drinit already runs in a Leaf, ie. after a re-exec
+func Delegated() (string, error) {
+	dir, e := Self()
+	if e != nil {
+		return "", e
+	}
+	if filepath.Base(dir) == Leaf {
+		return filepath.Dir(dir), nil
+	}
+	return dir, nil
+}
+
+// Memory - the memory cgroup of the container, the group delegated to
+// drinit on cgroup v2, or drinit's group of the cgroup v1 memory controller
+func Memory() (*Group, error) {
+	if dir, e := Delegated(); e == nil {
+		if g := Open(dir); exists(filepath.Join(dir, "memory.events")) {
+			return g, nil
+		}
+	}
+
+	mnt := v1mount("memory")
+	if len(mnt) == 0 {
+		return nil, errors.New("no memory cgroup")
+	}
+	b, e := ioutil.ReadFile("/proc/self/cgroup")
+	if e != nil {
+		return nil, e
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		f := strings.SplitN(l, ":", 3)
+		if len(f) != 3 || !contains(strings.Split(f[1], ","), "memory") {
+			continue
+		}
+		// the container's group is mounted as the root of the hierarchy
+		// when the path is not visible in the container
+		if dir := filepath.Join(mnt, f[2]); exists(dir) {
+			return Open(dir), nil
+		}
+		return Open(mnt), nil
+	}
+	return nil, errors.New("drinit is not in a memory cgroup")
+}
+
+// v1mount - the mount point of a cgroup v1 controller
+func v1mount(controller string) string {
+	f, e := os.Open("/proc/self/mounts")
+	if e != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 3 && fields[2] == "cgroup" && contains(strings.Split(fields[3], ","), controller) {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
+	_, e := os.Stat(path)
+	return e == nil
+}
+
+// Stats - what the processes of a group used, fields the kernel does not
+// report are 0
+type Stats struct {
+	// CPU, User, System - cpu time used by every process in the group
+	CPU, User, System time.Duration
+	// Memory, MemoryPeak - current and highest memory use in bytes
+	Memory, MemoryPeak int64
+	// Pids - the number of processes and threads in the group
+	Pids int64
+	// OOMKills - processes in the group killed by the oom killer
+	OOMKills int64
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("cpu: %v, user: %v, system: %v, memory: %d, peak: %d, pids: %d, oom kills: %d",
+		s.CPU, s.User, s.System, s.Memory, s.MemoryPeak, s.Pids, s.OOMKills)
+}
+
+// Group - a cgroup v2 directory, or a cgroup v1 memory group for OOMKills
+type Group struct {
+	Path string
+}
+
+// Open - the group at path, it is not created
+func Open(path string) *Group {
+	return &Group{Path: path}
+}
+
+// Name - the group's directory name
+func (g *Group) Name() string {
+	return filepath.Base(g.Path)
+}
+
+// Write - writes an interface file of the group, ie. memory.max
+func (g *Group) Write(file, value string) error {
+	if e := ioutil.WriteFile(filepath.Join(g.Path, file), []byte(value), 0); e != nil {
+		return fmt.Errorf("cgroup %s, %s", g.Path, e.Error())
+	}
+	return nil
+}
+
+// Read - reads an interface file of the group
+func (g *Group) Read(file string) (string, error) {
+	b, e := ioutil.ReadFile(filepath.Join(g.Path, file))
+	return strings.TrimSpace(string(b)), e
+}
+
+// Add - moves a process into the group, 0 is the calling process
+func (g *Group) Add(pid int) error {
+	return g.Write("cgroup.procs", strconv.Itoa(pid))
+}
+
+// Procs - the processes in the group, sub-groups are not included
+func (g *Group) Procs() ([]int, error) {
+	s, e := g.Read("cgroup.procs")
+	if e != nil {
+		return nil, e
+	}
+	var pids []int
+	for _, f := range strings.Fields(s) {
+		if pid, e := strconv.Atoi(f); e == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// Populated - the group or one of its sub-groups has processes
+func (g *Group) Populated() bool {
+	return g.keyed("cgroup.events")["populated"] != 0
+}
+
+// Stats - the group's cpu, memory and pids accounting
+func (g *Group) Stats() Stats {
+	cpu := g.keyed("cpu.stat")
+	return Stats{
+		CPU:        time.Duration(cpu["usage_usec"]) * time.Microsecond,
+		User:       time.Duration(cpu["user_usec"]) * time.Microsecond,
+		System:     time.Duration(cpu["system_usec"]) * time.Microsecond,
+		Memory:     g.value("memory.current"),
+		MemoryPeak: g.value("memory.peak"),
+		Pids:       g.value("pids.current"),
+		OOMKills:   g.keyed("memory.events")["oom_kill"],
+	}
+}
+
+// OOMKills - the number of processes in the group and its sub-groups
+// killed by the oom killer, read from memory.events, or memory.oom_control
+// of a cgroup v1 memory group, false when neither reports it
+func (g *Group) OOMKills() (int64, bool) {
+	for _, f := range []string{"memory.events", "memory.oom_control"} {
+		if n, ok := g.keyed(f)["oom_kill"]; ok {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// Kill - kills every process in the group and its sub-groups, kernels
+// without cgroup.kill have the processes killed one by one until the group
+// is empty
+func (g *Group) Kill() error {
+	e := g.Write("cgroup.kill", "1")
+	if e == nil {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(g.Path, "cgroup.kill")); !os.IsNotExist(err) {
+		return e
+	}
+	// processes can fork while they are killed
+	for pass := 0; pass < 3 && g.Populated(); pass++ {
+		g.walk(func(s *Group) {
+			pids, _ := s.Procs()
+			for _, pid := range pids {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// Wait - waits for the group to be empty, returns false if it still has
+// processes after d
+func (g *Group) Wait(d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for g.Populated() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Remove - removes the group and its sub-groups, they must be empty, a
+// group that is already removed is not an error
+func (g *Group) Remove() error {
+	var err error
+	g.walk(func(s *Group) {
+		if s == g {
+			return
+		}
+		if e := rmdir(s.Path); e != nil && err == nil {
+			err = e
+		}
+	})
+	if e := rmdir(g.Path); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func rmdir(path string) error {
+	if e := syscall.Rmdir(path); e != nil && e != syscall.ENOENT {
+		return fmt.Errorf("cgroup %s, %s", path, e.Error())
+	}
+	return nil
+}
+
+// Children - the group's sub-groups
+func (g *Group) Children() []*Group {
+	infos, e := ioutil.ReadDir(g.Path)
+	if e != nil {
+		return nil
+	}
+	var kids []*Group
+	for _, fi := range infos {
+		if fi.IsDir() {
+			kids = append(kids, Open(filepath.Join(g.Path, fi.Name())))
+		}
+	}
+	return kids
+}
+
+// walk - calls f for every sub-group, deepest first, and then for g
+func (g *Group) walk(f func(*Group)) {
+	for _, k := range g.Children() {
+		k.walk(f)
+	}
+	f(g)
+}
+
+// value - a single value file, max and missing files are 0
+func (g *Group) value(file string) int64 {
+	s, e := g.Read(file)
+	if e != nil {
+		return 0
+	}
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+// keyed - a flat keyed file, ie. cpu.stat
+func (g *Group) keyed(file string) map[string]int64 {
+	s, e := g.Read(file)
+	if e != nil {
+		return nil
+	}
+	m := make(map[string]int64)
+	for _, l := range strings.Split(s, "\n") {
+		f := strings.Fields(l)
+		if len(f) != 2 {
+			continue
+		}
+		if v, e := strconv.ParseInt(f[1], 10, 64); e == nil {
+			m[f[0]] = v
+		}
+	}
+	return m
+}
+
+// Tree - a delegated cgroup v2 subtree, drinit runs in its Leaf and every
+// program, trap and script run gets a sub-group of its own
+type Tree struct {
+	root *Group
+	lok  sync.Mutex
+	seq  int
+	ctl  map[string]bool
+}
+
+// NewTree - takes over the delegated group at dir, the processes in it are
+// moved to its Leaf and the available Controllers are enabled for its
+// sub-groups
+func NewTree(dir string) (*Tree, error) {
+	if !IsV2(dir) {
+		return nil, fmt.Errorf("%s is not a cgroup v2 group", dir)
+	}
+	t := &Tree{root: Open(dir), ctl: make(map[string]bool)}
+	leaf := Open(filepath.Join(dir, Leaf))
+	if e := os.Mkdir(leaf.Path, 0755); e != nil && !os.IsExist(e) {
+		return nil, fmt.Errorf("cgroup %s, %s", leaf.Path, e.Error())
+	}
+	pids, e := t.root.Procs()
+	if e != nil {
+		return nil, fmt.Errorf("cgroup %s, %s", dir, e.Error())
+	}
+	for _, pid := range pids {
+		// the process may have exited
+		if e := leaf.Add(pid); e != nil && !errors.Is(e, syscall.ESRCH) {
+			if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err == nil {
+				return nil, e
+			}
+		}
+	}
+
+	avail, _ := t.root.Read("cgroup.controllers")
+	for _, c := range strings.Fields(avail) {
+		for _, want := range Controllers {
+			if c != want {
+				continue
+			}
+			// one controller failing does not stop the others
+			if e := t.root.Write("cgroup.subtree_control", "+"+c); e == nil {
+				t.ctl[c] = true
+			}
+		}
+	}
+	return t, nil
+}
+
+// Path - the tree's root directory
+func (t *Tree) Path() string {
+	return t.root.Path
+}
+
+// Enabled - the controller is enabled for the tree's sub-groups
+func (t *Tree) Enabled(controller string) bool {
+	return t.ctl[controller]
+}
+
+// Check - returns an error if a limit needs a controller that is not
+// enabled
+func (t *Tree) Check(l Limits) error {
+	for _, c := range l.controllers() {
+		if !t.ctl[c] {
+			return fmt.Errorf("cgroup %s, the %s controller is not available", t.root.Path, c)
+		}
+	}
+	return nil
+}
+
+// New - creates the sub-group <name>.<seq> with limits l
+func (t *Tree) New(name string, l Limits) (*Group, error) {
+	t.lok.Lock()
+	t.seq++
+	seq := t.seq
+	t.lok.Unlock()
+
+	g := Open(filepath.Join(t.root.Path, fmt.Sprintf("%s.%d", name, seq)))
+	if e := os.Mkdir(g.Path, 0755); e != nil {
+		return nil, fmt.Errorf("cgroup %s, %s", g.Path, e.Error())
+	}
+	if e := l.apply(g); e != nil {
+		g.Remove()
+		return nil, e
+	}
+	return g, nil
+}
+
+// Hook - creates a sub-group for each run, see exe.ExeOpts.Cgroup
+func (t *Tree) Hook(name string, l Limits) func() (*Group, error) {
+	return func() (*Group, error) {
+		return t.New(name, l)
+	}
+}
+
+// Groups - the sub-groups of the tree, drinit's Leaf is not included
+func (t *Tree) Groups() []*Group {
+	var gs []*Group
+	for _, g := range t.root.Children() {
+		if g.Name() != Leaf {
+			gs = append(gs, g)
+		}
+	}
+	return gs
+}
+
+// Procs - the processes in the tree's sub-groups, drinit's Leaf is not
+// included
+func (t *Tree) Procs() []int {
+	var pids []int
+	for _, g := range t.Groups() {
+		g.walk(func(s *Group) {
+			p, _ := s.Procs()
+			pids = append(pids, p...)
+		})
+	}
+	return pids
+}
+
+// Kill - kills the processes in every sub-group and removes them, returns
+// the number of groups that had processes
+func (t *Tree) Kill(wait time.Duration) int {
+	n := 0
+	for _, g := range t.Groups() {
+		if g.Populated() {
+			n++
+			g.Kill()
+		}
+	}
+	t.Clean(wait)
+	return n
+}
+
+// Clean - removes the sub-groups that are empty, or empty within wait
+func (t *Tree) Clean(wait time.Duration) {
+	for _, g := range t.Groups() {
+		if g.Wait(wait) {
+			g.Remove()
+		}
+	}
+}
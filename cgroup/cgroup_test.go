@@ -0,0 +1,143 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testtree - a tree in a new group under the cgroup v2 mount, skips the
+// test when there is none that drinit can write
+func testtree(t *testing.T) *Tree {
+	mnt := Mount()
+	if len(mnt) == 0 {
+		t.Skip("cgroup v2 is not mounted")
+	}
+	dir := filepath.Join(mnt, fmt.Sprintf("drinit-test-%d", os.Getpid()))
+	if e := os.Mkdir(dir, 0755); e != nil {
+		t.Skipf("cgroup v2 is not writable, %s", e.Error())
+	}
+	tree, e := NewTree(dir)
+	if !assert.NoError(t, e) {
+		Open(dir).Remove()
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		tree.Kill(time.Second)
+		Open(dir).Remove()
+	})
+	return tree
+}
+
+func TestParseLimits(t *testing.T) {
+	m, e := ToMemory("512M")
+	assert.NoError(t, e)
+	assert.Equal(t, "536870912", m)
+	m, e = ToMemory("max")
+	assert.NoError(t, e)
+	assert.Equal(t, Max, m)
+	_, e = ToMemory("lots")
+	assert.Error(t, e)
+
+	c, e := ToCPU("1.5")
+	assert.NoError(t, e)
+	assert.Equal(t, "150000 100000", c)
+	c, e = ToCPU("50000:200000")
+	assert.NoError(t, e)
+	assert.Equal(t, "50000 200000", c)
+	_, e = ToCPU("0")
+	assert.Error(t, e)
+
+	p, e := ToPids("64")
+	assert.NoError(t, e)
+	assert.Equal(t, "64", p)
+	_, e = ToPids("-1")
+	assert.Error(t, e)
+
+	l := Limits{Memory: "1024", Pids: Max}
+	assert.Equal(t, "memory.max=1024, pids.max=max", l.String())
+	assert.Equal(t, []string{"memory", "pids"}, l.controllers())
+}
+
+func TestTree(t *testing.T) {
+	tree := testtree(t)
+	assert.DirExists(t, filepath.Join(tree.Path(), Leaf))
+
+	g, e := tree.New("program", Limits{})
+	assert.NoError(t, e)
+	assert.Equal(t, "program.1", g.Name())
+	assert.False(t, g.Populated())
+
+	// a process and its fork, which escapes the process group, are killed
+	// together
+	cmd := exec.Command("sh", "-c", "setsid sleep 60 & exec sleep 60")
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, g.Add(cmd.Process.Pid))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, g.Populated())
+	assert.Len(t, tree.Procs(), 1, "the fork happened before the move")
+
+	assert.NoError(t, g.Kill())
+	cmd.Wait()
+	assert.True(t, g.Wait(time.Second))
+	assert.True(t, g.Stats().CPU >= 0)
+
+	// drinit and the run's exe may both remove an empty group
+	assert.NoError(t, g.Remove())
+	assert.NoError(t, g.Remove())
+
+	h, e := tree.New("trap", Limits{})
+	assert.NoError(t, e)
+	assert.Equal(t, "trap.2", h.Name())
+	cmd = exec.Command("sh", "-c", "read x")
+	in, _ := cmd.StdinPipe()
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, h.Add(cmd.Process.Pid))
+	sleeper := exec.Command("sleep", "60")
+	assert.NoError(t, sleeper.Start())
+	assert.NoError(t, h.Add(sleeper.Process.Pid))
+	_, e = tree.New("script", Limits{})
+	assert.NoError(t, e)
+	assert.Len(t, tree.Groups(), 2)
+	assert.ElementsMatch(t, []int{cmd.Process.Pid, sleeper.Process.Pid}, tree.Procs())
+
+	// the empty group is removed, the populated one is killed and removed
+	assert.Equal(t, 1, tree.Kill(time.Second))
+	cmd.Wait()
+	sleeper.Wait()
+	in.Close()
+	assert.Empty(t, tree.Groups())
+
+	if !tree.Enabled("pids") {
+		assert.Error(t, tree.Check(Limits{Pids: "10"}))
+		return
+	}
+	assert.NoError(t, tree.Check(Limits{Pids: "10"}))
+	g, e = tree.New("program", Limits{Pids: "10"})
+	assert.NoError(t, e)
+	v, _ := g.Read("pids.max")
+	assert.Equal(t, "10", v)
+}
@@ -0,0 +1,161 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgroup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Max - no limit
+const Max = "max"
+
+// DefaultPeriod - the cpu.max period used for a limit given in cpus
+const DefaultPeriod = 100000
+
+// Limits - values written to a group's memory.max, cpu.max and pids.max,
+// empty values are not written
+type Limits struct {
+	Memory string
+	CPU    string
+	Pids   string
+}
+
+func (l Limits) String() string {
+	var s []string
+	if len(l.Memory) > 0 {
+		s = append(s, "memory.max="+l.Memory)
+	}
+	if len(l.CPU) > 0 {
+		s = append(s, "cpu.max="+l.CPU)
+	}
+	if len(l.Pids) > 0 {
+		s = append(s, "pids.max="+l.Pids)
+	}
+	return strings.Join(s, ", ")
+}
+
+// controllers - the controllers the limits need
+func (l Limits) controllers() []string {
+	var c []string
+	if len(l.CPU) > 0 {
+		c = append(c, "cpu")
+	}
+	if len(l.Memory) > 0 {
+		c = append(c, "memory")
+	}
+	if len(l.Pids) > 0 {
+		c = append(c, "pids")
+	}
+	return c
+}
+
+func (l Limits) apply(g *Group) error {
+	for _, f := range []struct{ file, value string }{
+		{"memory.max", l.Memory},
+		{"cpu.max", l.CPU},
+		{"pids.max", l.Pids},
+	} {
+		if len(f.value) == 0 {
+			continue
+		}
+		if e := g.Write(f.file, f.value); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ToMemory - parses a memory limit, bytes with an optional K, M, G or T
+// suffix, or max
+func ToMemory(spec string) (string, error) {
+	s := strings.ToUpper(strings.TrimSpace(spec))
+	if s == "MAX" {
+		return Max, nil
+	}
+	mul := uint64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mul = 1 << 10
+		case 'M':
+			mul = 1 << 20
+		case 'G':
+			mul = 1 << 30
+		case 'T':
+			mul = 1 << 40
+		}
+		if mul > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, e := strconv.ParseUint(s, 10, 64)
+	if e != nil || v == 0 {
+		return "", fmt.Errorf("invalid memory limit: %s", spec)
+	}
+	return strconv.FormatUint(v*mul, 10), nil
+}
+
+// ToCPU - parses a cpu limit, a number of cpus, ie. 1.5, a cpu.max quota
+// and period in microseconds, ie. 50000:100000, or max
+func ToCPU(spec string) (string, error) {
+	s := strings.TrimSpace(spec)
+	if s == Max {
+		return Max, nil
+	}
+	if q, p, ok := cut(s, ":"); ok {
+		quota, e1 := strconv.ParseUint(q, 10, 64)
+		period, e2 := strconv.ParseUint(p, 10, 64)
+		if e1 != nil || e2 != nil || quota == 0 || period == 0 {
+			return "", fmt.Errorf("invalid cpu limit: %s", spec)
+		}
+		return fmt.Sprintf("%d %d", quota, period), nil
+	}
+	cpus, e := strconv.ParseFloat(s, 64)
+	if e != nil || cpus <= 0 {
+		return "", fmt.Errorf("invalid cpu limit: %s", spec)
+	}
+	// the kernel's minimum quota is 1ms
+	quota := int64(cpus * DefaultPeriod)
+	if quota < 1000 {
+		quota = 1000
+	}
+	return fmt.Sprintf("%d %d", quota, DefaultPeriod), nil
+}
+
+// ToPids - parses a limit on the number of processes, or max
+func ToPids(spec string) (string, error) {
+	s := strings.TrimSpace(spec)
+	if s == Max {
+		return Max, nil
+	}
+	v, e := strconv.ParseUint(s, 10, 64)
+	if e != nil || v == 0 {
+		return "", fmt.Errorf("invalid pids limit: %s", spec)
+	}
+	return strconv.FormatUint(v, 10), nil
+}
+
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
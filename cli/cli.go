@@ -19,6 +19,7 @@ limitations under the License.
 package cli
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"os"
@@ -43,6 +44,19 @@ func (s *sslice) String() string {
 	return fmt.Sprintf("%v", *s)
 }
 
+type slist struct {
+	slice []string
+}
+
+func (s *slist) Set(value string) error {
+	s.slice = append(s.slice, value)
+	return nil
+}
+
+func (s *slist) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
 type islice struct {
 	slice []int
 }
@@ -95,6 +109,17 @@ func (c *Cli) StringSlice(name, shortname, usage string) *[]string {
 	return &s.slice
 }
 
+// Strings - creates a repeatable flag that is parsed into a string slice,
+// unlike StringSlice each value is kept whole, commas included
+func (c *Cli) Strings(name, shortname, usage string) *[]string {
+	s := new(slist)
+	c.flags.Var(s, name, usage)
+	if len(shortname) > 0 {
+		c.flags.Var(s, shortname, usage)
+	}
+	return &s.slice
+}
+
 // String - creates a flag that is parsed into a string
 func (c *Cli) String(name, shortname, value, usage string) *string {
 	var str string
@@ -167,3 +192,66 @@ func (c *Cli) NArg() int {
 func (c *Cli) Args() []string {
 	return c.flags.Args()
 }
+
+// Peek - returns the value of a flag in args before they are parsed, names
+// are the flag's name and shortname
+func (c *Cli) Peek(args []string, names ...string) (string, bool) {
+	for n := 0; n < len(args); n++ {
+		a := args[n]
+		if a == "--" || len(a) < 2 || a[0] != '-' {
+			return "", false
+		}
+
+		k, v, set := strings.TrimLeft(a, "-"), "", false
+		if eq := strings.Index(k, "="); eq >= 0 {
+			k, v, set = k[:eq], k[eq+1:], true
+		}
+		f := c.flags.Lookup(k)
+		if f == nil {
+			return "", false
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if !set && n+1 < len(args) {
+			n++
+			v = args[n]
+		}
+		for _, name := range names {
+			if k == name {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ReadArgs - reads flags from a file, one flag per line as name or
+// name value, blank lines and lines starting with # are skipped
+func ReadArgs(path string) ([]string, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	var args []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		kv := []string{line}
+		if i := strings.IndexAny(line, "= \t"); i >= 0 {
+			kv = []string{line[:i], strings.TrimSpace(line[i+1:])}
+		}
+		name := "-" + strings.TrimLeft(kv[0], "-")
+		if len(kv) == 1 || len(kv[1]) == 0 {
+			args = append(args, name)
+			continue
+		}
+		args = append(args, name+"="+kv[1])
+	}
+	return args, sc.Err()
+}
@@ -19,6 +19,8 @@ limitations under the License.
 package cli
 
 import (
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -88,3 +90,44 @@ func run(t *testing.T, cmd []string) {
 	assert.Equal(t, 3*time.Second, *dptr, "should be equal")
 	assert.Equal(t, 1, narg, "should be equal")
 }
+
+func TestStrings(t *testing.T) {
+	cli := New("test")
+	sslc := cli.Strings("strings", "a", "a repeatable string")
+
+	assert.NoError(t, cli.ParseSlice([]string{"-strings", "a, b", "-a", "c"}))
+	assert.Equal(t, []string{"a, b", "c"}, *sslc, "values should not be split")
+}
+
+func TestConfig(t *testing.T) {
+	f, _ := ioutil.TempFile("", "drinit-config")
+	defer os.Remove(f.Name())
+	f.WriteString("# a comment\nstring test\n\n--strings=a, b\nbool\nduration 3s\n")
+	f.Close()
+
+	cli := New("test")
+	cli.String("config", "c", "", "a config file")
+	sptr := cli.String("string", "s", "", "a string")
+	sslc := cli.StringSlice("strings", "a", "a string slice")
+	bptr := cli.Bool("bool", "b", false, "a boolean")
+	dptr := cli.Duration("duration", "d", time.Second, "a duration")
+
+	args := []string{"-b", "-s", "override", "-c", f.Name(), "-a", "c", "--", "unknown"}
+	path, ok := cli.Peek(args, "config", "c")
+	assert.True(t, ok)
+	assert.Equal(t, f.Name(), path)
+
+	fargs, err := ReadArgs(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-string=test", "-strings=a, b", "-bool", "-duration=3s"}, fargs)
+
+	assert.NoError(t, cli.ParseSlice(append(fargs, args...)))
+	assert.Equal(t, "override", *sptr, "the command line should win")
+	assert.Equal(t, []string{"a", "b", "c"}, *sslc)
+	assert.True(t, *bptr)
+	assert.Equal(t, 3*time.Second, *dptr)
+	assert.Equal(t, []string{"unknown"}, cli.Args())
+
+	_, ok = cli.Peek([]string{"-s", "x", "--", "-c", "y"}, "config", "c")
+	assert.False(t, ok, "flags after -- are not flags")
+}
@@ -19,11 +19,13 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"os"
 	"os/user"
 	"strconv"
 	"syscall"
 
+	"github.com/streamz/drinit/cgroup"
 	"github.com/streamz/drinit/exe"
 	"github.com/streamz/drinit/ini"
 	"github.com/streamz/drinit/log"
@@ -40,8 +42,32 @@ func main() {
 
 	l.Infof("drinit %s", c.String())
 
-	h := func(s os.Signal) error {
-		args := c.TrapArgs
+	// the program may run as another user, traps run as drinit's user
+	pu := u
+	if c.User != nil {
+		pu = c.User
+	}
+
+	var tree *cgroup.Tree
+	if c.Cgroup {
+		dir, e := cgroup.Delegated()
+		if e == nil {
+			tree, e = cgroup.NewTree(dir)
+		}
+		if e == nil {
+			e = tree.Check(c.CgroupLimits)
+		}
+		if e != nil {
+			l.Panicf("cgroups, %s", e.Error())
+		}
+		l.Infof("cgroups under %s", tree.Path())
+	}
+
+	h := func(ctx context.Context, s os.Signal) error {
+		args, ok := c.Actions[s]
+		if !ok {
+			args = c.TrapArgs
+		}
 		sz := len(args)
 
 		l.Tracef("received signal: %s, trap args: %d", s.String(), sz)
@@ -49,23 +75,67 @@ func main() {
 			return nil
 		}
 
-		exc := exe.New(u)
+		opts := &exe.ExeOpts{
+			Osusr:   u,
+			Role:    exe.RoleTrap,
+			Capture: exe.DefaultCapture,
+			Stdin:   exe.StdinNull,
+			Limits:  c.Limits,
+		}
+		if tree != nil {
+			opts.Cgroup = tree.Hook(exe.RoleTrap, cgroup.Limits{})
+		}
+		exc := exe.NewWithOpts(opts)
 		switch sz {
 		case 1: // runs a shell script and passed signal is $1
 			l.Tracef("invoking cmd: %s with signal: %s", args[0], s.String())
-			return exc.Run(args[0], strconv.Itoa(sig.SignalToNumber(s.(syscall.Signal)))).Error
+			return runtrap(ctx, exc, args[0], strconv.Itoa(sig.SignalToNumber(s.(syscall.Signal))))
 		default:
 			l.Tracef("invoking cmd: %s with args: %+v", args[0], args[1:])
-			return exc.Run(args[0], args[1:]...).Error
+			return runtrap(ctx, exc, args[0], args[1:]...)
 		}
 	}
 
 	o := &ini.InitOpts{
-		Traps: c.Traps,
-		Signf: h,
-		Osusr: u,
+		Traps:         c.Traps,
+		Trapc:         h,
+		Modes:         c.Modes,
+		Rules:         c.Rules,
+		Depth:         c.Depth,
+		Timeouts:      c.Timeouts,
+		Remap:         c.Remap,
+		Idle:          c.Idle,
+		Grace:         c.Grace,
+		Diags:         c.Diags,
+		History:       c.History,
+		HistoryFile:   c.HistoryFile,
+		Subreaper:     c.Subreaper,
+		Teardown:      c.Teardown,
+		ScriptTimeout: c.ScriptTimeout,
+		Tty:           c.Tty,
+		Pty:           c.Pty,
+		Stdin:         c.Stdin,
+		Events:        c.Events,
+		Osusr:         pu,
+		Osgrp:         c.Group,
+		Caps:          c.Caps,
+		Limits:        c.Limits,
+		Cgroups:       tree,
+		CgroupLimits:  c.CgroupLimits,
+		RestartOOM:    c.RestartOOM,
+		RestartDelay:  c.RestartDelay,
 	}
 
 	i := ini.New(c.Supervise, c.Pipe, o)
 	i.Start()
 }
+
+// runtrap - runs a trap script, stopping it when ctx is done
+func runtrap(ctx context.Context, exc *exe.Exe, name string, args ...string) error {
+	info := exc.RunContext(ctx, name, args...)
+	// the signal handler logs the error, the output is only known here
+	if info.Error != nil && len(info.Output) > 0 {
+		log.Logger().Errorf("trap %s failed, %s%s", name, info.Error.Error(), info.Captured())
+	}
+	return info.Error
+}
@@ -19,10 +19,12 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/streamz/drinit/cli"
 	"github.com/streamz/drinit/ipc"
@@ -33,10 +35,16 @@ import (
 func main() {
 	c := newcli()
 	l := log.Logger()
+	// a failed request is reported as an error, not a panic, the action
+	// may still be carried out by drinit
+	fail := func(e error) {
+		l.Error(e.Error())
+		os.Exit(1)
+	}
 	m := map[int]string{
 		_cycle: ipc.Cycle,
-		_down: ipc.Down,
-		_up: ipc.Up,
+		_down:  ipc.Down,
+		_up:    ipc.Up,
 	}
 
 	l.Level(c.level)
@@ -50,9 +58,18 @@ func main() {
 			Name: cmd,
 			Args: c.run,
 		}
-		if e := ipc.Send(c.pipe, msg); e != nil {
-			l.Panic(e.Error())
+		if len(c.run) == 0 {
+			if e := ipc.Send(c.pipe, msg); e != nil {
+				fail(e)
+			}
+			break
 		}
+		// wait for the script, drinit replies with its output
+		res, e := ipc.Request(c.pipe, msg, c.wait)
+		if e != nil {
+			fail(e)
+		}
+		fmt.Print(res)
 	case _signal:
 		l.Tracef("sending signal %v to service", c.signal)
 		msg := ipc.Msg{
@@ -60,8 +77,31 @@ func main() {
 			Args: []string{sig.SignalToName(c.signal)},
 		}
 		if e := ipc.Send(c.pipe, msg); e != nil {
-			l.Panic(e.Error())
+			fail(e)
+		}
+	case _query:
+		if c.query == ipc.Stdin && len(c.run) == 0 {
+			// each line drinitctl reads is written to the program
+			sc := bufio.NewScanner(os.Stdin)
+			for sc.Scan() {
+				res, e := ipc.Request(c.pipe, ipc.Msg{Name: ipc.Stdin, Args: []string{sc.Text()}}, c.wait)
+				if e != nil {
+					fail(e)
+				}
+				fmt.Print(res)
+			}
+			break
+		}
+		l.Tracef("query %s %s", c.query, strings.Join(c.run, " "))
+		msg := ipc.Msg{
+			Name: c.query,
+			Args: c.run,
+		}
+		res, e := ipc.Request(c.pipe, msg, c.wait)
+		if e != nil {
+			fail(e)
 		}
+		fmt.Print(res)
 	}
 }
 
@@ -69,10 +109,22 @@ func main() {
 const verbosemsg = "verbose logging"
 const helpemsg = "displays help usage"
 const fdmsg = "the ipc named pipe"
-const signalmsg = "send a signal to the supervised process"
+const signalmsg = "send a signal to the supervised process, by name, ie. TERM, SIGRTMIN+1, or number"
+const listmsg = "list the supported signals"
 const commandmsg = "1 - CYCLE, 2 - UP or 3 - DOWN the supervised service"
 const runmsg = "the command to run before DOWN, after UP service command"
-const usage = "/drinitctl -c2 -r echo stopping"
+const waitmsg = "how long to wait for a response to a query, default 5s, or for the -r script to finish, by default for as long as it runs"
+const usage = "/drinitctl -c2 -r echo stopping, /drinitctl history, or /drinitctl stdin some input"
+
+// queries - commands that print a response from drinit
+var queries = map[string]struct{}{
+	ipc.History: {},
+	ipc.Status:  {},
+	ipc.Metrics: {},
+	ipc.Ps:      {},
+	ipc.Signals: {},
+	ipc.Stdin:   {},
+}
 
 const (
 	// cycle the service
@@ -91,6 +143,8 @@ func (m mode) String() string {
 		return "PROC"
 	case _signal:
 		return "SIGNAL"
+	case _query:
+		return "QUERY"
 	}
 	return "INVALID"
 }
@@ -101,6 +155,9 @@ const (
 	_proc
 	// signal child
 	_signal
+	// query drinit (history, status, metrics, ps, signals), or write the
+	// program's stdin
+	_query
 )
 
 type clictx struct {
@@ -109,13 +166,15 @@ type clictx struct {
 	command int
 	signal  syscall.Signal
 	ctlmode mode
+	query   string
+	wait    time.Duration
 	run     []string
 }
 
 func (c *clictx) String() string {
 	return fmt.Sprintf(
-		"level: %s, pipe: %s, command: %d, signal: %s, mode: %s, run: %v",
-		c.level.String(), c.pipe, c.command, c.signal.String(), c.ctlmode.String(), c.run)
+		"level: %s, pipe: %s, command: %d, signal: %s, mode: %s, query: %s, run: %v",
+		c.level.String(), c.pipe, c.command, c.signal.String(), c.ctlmode.String(), c.query, c.run)
 }
 
 func newcli() *clictx {
@@ -126,6 +185,8 @@ func newcli() *clictx {
 	command := cmd.Int("command", "c", 0, commandmsg)
 	verbose := cmd.Bool("verbose", "v", false, verbosemsg)
 	run := cmd.String("run", "r", "", runmsg)
+	wait := cmd.Duration("wait", "w", 0, waitmsg)
+	list := cmd.Bool("list", "l", false, listmsg)
 	exit := func() {
 		cmd.Usage(usage)
 		os.Exit(0)
@@ -142,35 +203,56 @@ func newcli() *clictx {
 		exit()
 	}
 
+	if *list {
+		for _, s := range sig.Signals() {
+			fmt.Printf("%2d %s\n", sig.SignalToNumber(s), sig.SignalToName(s))
+		}
+		os.Exit(0)
+	}
+
 	level := log.ErrorL
 	if *verbose {
 		level = log.TraceL
 	}
 
 	ctx := &clictx{
-		level: level,
-		pipe: *pipe,
+		level:   level,
+		pipe:    *pipe,
 		ctlmode: _invalid,
-		run: []string{},
+		wait:    *wait,
+		run:     []string{},
 	}
 
 	// order of preference if cmd has multiple options
-	if len(*signal) > 1 {
+	if len(*signal) > 0 {
 		ctx.ctlmode = _signal
 	}
 	if *command >= _cycle && *command <= _down {
 		ctx.ctlmode = _proc
 		ctx.command = *command
 	}
+	if cmd.NArg() > 0 {
+		if _, ok := queries[cmd.Arg(0)]; ok {
+			ctx.ctlmode = _query
+			ctx.query = cmd.Arg(0)
+			ctx.run = cmd.Args()[1:]
+		}
+	}
 
 	switch ctx.ctlmode {
 	case _proc:
 		if len(*run) > 0 {
 			ctx.run = strings.Split(strings.Trim(*run, " "), " ")
 		}
+	case _query:
+		// drinit answers queries at once, a -r script may run for long
+		if ctx.wait == 0 {
+			ctx.wait = 5 * time.Second
+		}
 	case _signal:
 		s, e := sig.ToSignal(*signal)
 		if e != nil {
+			fmt.Fprintln(os.Stderr, e.Error())
 			exit()
 		}
 		ctx.signal = s.(syscall.Signal)
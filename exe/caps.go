@@ -0,0 +1,149 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Caps - the capabilities of a child
+type Caps struct {
+	// Bounding - the capabilities kept in the bounding set, the rest are
+	// dropped, nil keeps the bounding set unchanged
+	Bounding []uintptr
+	// Ambient - the capabilities raised in the ambient set, they are kept
+	// when the child runs as a non-root user
+	Ambient []uintptr
+	// NoNewPrivs - set PR_SET_NO_NEW_PRIVS, setuid and file capabilities
+	// do not grant privileges to the child or its descendants
+	NoNewPrivs bool
+}
+
+// prctl options
+const (
+	_PR_CAPBSET_DROP     = 24
+	_PR_SET_NO_NEW_PRIVS = 38
+)
+
+var capnames = []string{
+	"CHOWN", "DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER", "FSETID", "KILL",
+	"SETGID", "SETUID", "SETPCAP", "LINUX_IMMUTABLE", "NET_BIND_SERVICE",
+	"NET_BROADCAST", "NET_ADMIN", "NET_RAW", "IPC_LOCK", "IPC_OWNER",
+	"SYS_MODULE", "SYS_RAWIO", "SYS_CHROOT", "SYS_PTRACE", "SYS_PACCT",
+	"SYS_ADMIN", "SYS_BOOT", "SYS_NICE", "SYS_RESOURCE", "SYS_TIME",
+	"SYS_TTY_CONFIG", "MKNOD", "LEASE", "AUDIT_WRITE", "AUDIT_CONTROL",
+	"SETFCAP", "MAC_OVERRIDE", "MAC_ADMIN", "SYSLOG", "WAKE_ALARM",
+	"BLOCK_SUSPEND", "AUDIT_READ", "PERFMON", "BPF", "CHECKPOINT_RESTORE",
+}
+
+// CapToName - the name of a capability, ie. CAP_NET_BIND_SERVICE
+func CapToName(c uintptr) string {
+	if int(c) < len(capnames) {
+		return "CAP_" + capnames[c]
+	}
+	return fmt.Sprintf("CAP_%d", c)
+}
+
+// ToCap - parses a capability by name, with or without the CAP_ prefix and
+// in any case, or by number
+func ToCap(name string) (uintptr, error) {
+	n := strings.ToUpper(strings.TrimSpace(name))
+	if c, e := strconv.Atoi(n); e == nil && c >= 0 && c <= lastcap() {
+		return uintptr(c), nil
+	}
+	n = strings.TrimPrefix(n, "CAP_")
+	for c, cn := range capnames {
+		if cn == n {
+			return uintptr(c), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid capability: %s", name)
+}
+
+// ToCaps - parses a list of capabilities, each entry may be a comma
+// separated list, none is an empty set
+func ToCaps(names []string) ([]uintptr, error) {
+	caps := []uintptr{}
+	for _, entry := range names {
+		for _, n := range strings.Split(entry, ",") {
+			if strings.EqualFold(strings.TrimSpace(n), "none") {
+				continue
+			}
+			c, e := ToCap(n)
+			if e != nil {
+				return nil, e
+			}
+			caps = append(caps, c)
+		}
+	}
+	return caps, nil
+}
+
+// lastcap - the highest capability the kernel supports
+func lastcap() int {
+	b, e := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if e == nil {
+		if n, e := strconv.Atoi(strings.TrimSpace(string(b))); e == nil {
+			return n
+		}
+	}
+	return len(capnames) - 1
+}
+
+// restricted - the bounding set or no_new_privs are changed for the child
+func (c *Caps) restricted() bool {
+	return c.Bounding != nil || c.NoNewPrivs
+}
+
+// restrict - applies the bounding set and no_new_privs to the calling
+// thread, only the shim calls it, on the thread that executes the program
+func (c *Caps) restrict() error {
+	if c.Bounding != nil {
+		keep := make(map[uintptr]bool, len(c.Bounding))
+		for _, b := range c.Bounding {
+			keep[b] = true
+		}
+		for n := 0; n <= lastcap(); n++ {
+			if keep[uintptr(n)] {
+				continue
+			}
+			if e := prctl(_PR_CAPBSET_DROP, uintptr(n)); e != nil {
+				return fmt.Errorf("failed to drop %s from the bounding set, %s", CapToName(uintptr(n)), e.Error())
+			}
+		}
+	}
+	if c.NoNewPrivs {
+		if e := prctl(_PR_SET_NO_NEW_PRIVS, 1); e != nil {
+			return fmt.Errorf("failed to set no_new_privs, %s", e.Error())
+		}
+	}
+	return nil
+}
+
+func prctl(option, arg uintptr) error {
+	_, _, e := syscall.RawSyscall6(syscall.SYS_PRCTL, option, arg, 0, 0, 0, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
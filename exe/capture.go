@@ -0,0 +1,72 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultCapture - a sensible capture size for scripts
+const DefaultCapture = 64 * 1024
+
+// capture - keeps the last max bytes written to it
+type capture struct {
+	lok sync.Mutex
+	max int
+	buf []byte
+	cut bool
+}
+
+func newcapture(max int) *capture {
+	return &capture{max: max}
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.lok.Lock()
+	defer c.lok.Unlock()
+
+	c.buf = append(c.buf, p...)
+	if over := len(c.buf) - c.max; over > 0 {
+		c.buf = append(c.buf[:0], c.buf[over:]...)
+		c.cut = true
+	}
+	return len(p), nil
+}
+
+// output - the captured bytes and whether older output was discarded
+func (c *capture) output() (string, bool) {
+	c.lok.Lock()
+	defer c.lok.Unlock()
+	return string(c.buf), c.cut
+}
+
+// Captured - the captured output formatted for a log line, empty if
+// nothing was captured
+func (i *Info) Captured() string {
+	if len(i.Output) == 0 {
+		return ""
+	}
+	cut := ""
+	if i.Truncated {
+		cut = " (truncated)"
+	}
+	return fmt.Sprintf(", output%s:\n%s", cut, strings.TrimRight(i.Output, "\n"))
+}
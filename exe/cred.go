@@ -0,0 +1,117 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// LookupUser - finds a user by name or uid, a uid without a passwd entry
+// is used as is with its group id equal to the uid
+func LookupUser(name string) (*user.User, error) {
+	if u, e := user.Lookup(name); e == nil {
+		return u, nil
+	}
+	u, e := user.LookupId(name)
+	if e == nil {
+		return u, nil
+	}
+	if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+		return nil, e
+	}
+	return &user.User{Uid: name, Gid: name, Username: name, HomeDir: "/"}, nil
+}
+
+// LookupGroup - finds a group by name or gid, a gid without a group entry
+// is used as is
+func LookupGroup(name string) (*user.Group, error) {
+	if g, e := user.LookupGroup(name); e == nil {
+		return g, nil
+	}
+	g, e := user.LookupGroupId(name)
+	if e == nil {
+		return g, nil
+	}
+	if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+		return nil, e
+	}
+	return &user.Group{Gid: name, Name: name}, nil
+}
+
+// LookupUserGroup - finds user or user:group, the group replaces the
+// user's primary group
+func LookupUserGroup(spec string) (*user.User, *user.Group, error) {
+	name, grp := spec, ""
+	if n := strings.IndexByte(spec, ':'); n >= 0 {
+		name, grp = spec[:n], spec[n+1:]
+	}
+	u, e := LookupUser(name)
+	if e != nil || len(grp) == 0 {
+		return u, nil, e
+	}
+	g, e := LookupGroup(grp)
+	if e != nil {
+		return nil, nil, e
+	}
+	return u, g, nil
+}
+
+// credential - the uid, gid and supplementary groups of the child, the
+// supplementary groups are only set when drinit is root
+func (x *Exe) credential() *syscall.Credential {
+	uid, _ := strconv.Atoi(x.usr.Uid)
+	gid, _ := strconv.Atoi(x.usr.Gid)
+	if x.opt.Osgrp != nil {
+		gid, _ = strconv.Atoi(x.opt.Osgrp.Gid)
+	}
+
+	cred := &syscall.Credential{
+		Uid:         uint32(uid),
+		Gid:         uint32(gid),
+		NoSetGroups: true,
+	}
+	if os.Geteuid() != 0 {
+		return cred
+	}
+	cred.NoSetGroups = false
+	cred.Groups = groups(x.usr, uint32(gid))
+	return cred
+}
+
+// groups - the supplementary groups of u from /etc/group, without its
+// primary group, none if u has no passwd entry
+func groups(u *user.User, primary uint32) []uint32 {
+	ids, e := u.GroupIds()
+	if e != nil {
+		return []uint32{}
+	}
+	gids := make([]uint32, 0, len(ids))
+	for _, id := range ids {
+		gid, e := strconv.ParseUint(id, 10, 32)
+		if e != nil || uint32(gid) == primary {
+			continue
+		}
+		gids = append(gids, uint32(gid))
+	}
+	return gids
+}
@@ -19,15 +19,17 @@ limitations under the License.
 package exe
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/streamz/drinit/cgroup"
 	"github.com/streamz/drinit/log"
 	"github.com/streamz/drinit/util"
 )
@@ -39,13 +41,82 @@ func (*noCopy) Unlock() {}
 
 // Info -
 type Info struct {
-	Error error
-	RunT time.Duration
-	Pid, Exit int
+	Error        error
+	RunT         time.Duration
+	Pid, Exit    int
 	StartT, EndT int64
+	// OutT - the last time the program wrote to stdout or stderr,
+	// only tracked when the output is proxied
+	OutT int64
+	// Status - the exit status, -1 if the program was killed by a signal
+	Status int
+	// Signal - the signal that terminated the program, 0 if it exited
+	Signal syscall.Signal
+	// Core - the program dumped core
+	Core bool
+	// Utime, Stime - user and system cpu time used by the program
+	Utime, Stime time.Duration
+	// MaxRSS - the maximum resident set size in kilobytes
+	MaxRSS             int64
 	Finished, Signaled util.AtomicBool
+	// Stopped - the program is stopped by job control, ie. SIGTSTP, only
+	// tracked for foreground programs
+	Stopped util.AtomicBool
+	// Output - the last ExeOpts.Capture bytes of stdout and stderr,
+	// Truncated - earlier output was discarded
+	Output    string
+	Truncated bool
+	// Cgroup - the child's cgroup v2 group, Usage - what the group used,
+	// set once the child has exited
+	Cgroup string
+	Usage  *cgroup.Stats
 }
 
+func (i Info) String() string {
+	return fmt.Sprintf(
+		"pid: %d, exit: %d, status: %d, signal: %d, core: %v, utime: %v, stime: %v, maxrss: %dK, error: %v",
+		i.Pid, i.Exit, i.Status, int(i.Signal), i.Core, i.Utime, i.Stime, i.MaxRSS, i.Error)
+}
+
+// ExeOpts -
+type ExeOpts struct {
+	Osusr *user.User
+	// Osgrp - the child's primary group, the primary group of Osusr when nil
+	Osgrp *user.Group
+	// Caps - the child's capability bounding and ambient sets
+	Caps Caps
+	// Proxy - copy the child's output through drinit instead of
+	// passing os.Stdout and os.Stderr to it
+	Proxy bool
+	// Role - what the child is for, reported by Roles while it runs
+	Role string
+	// Foreground - make the child's process group the foreground group of
+	// the terminal on stdin, and follow its stops and continues
+	Foreground bool
+	// Capture - keep the last Capture bytes of the child's output in
+	// Info.Output, the output is still written to drinit's stdio
+	Capture int
+	// Pty - run the child on a pseudo-terminal allocated by drinit, its
+	// output is copied to drinit's stdout, Foreground is ignored
+	Pty bool
+	// Stdin - what the child reads from, StdinInherit passes drinit's stdin
+	Stdin Stdin
+	// Limits - the child's resource limits and scheduling attributes
+	Limits Limits
+	// Cgroup - creates the cgroup v2 group the child is placed in before
+	// it executes, called for every start, the group is removed once it
+	// is empty after the child exits
+	Cgroup func() (*cgroup.Group, error)
+	// Stop - the signal sent to the process group when the context given
+	// to StartContext or RunContext is done, 0 sends SIGTERM, Grace - how
+	// long the group has to exit before SIGKILL, 0 uses DefaultGrace
+	Stop  syscall.Signal
+	Grace time.Duration
+}
+
+// DefaultGrace - how long a canceled program has to exit before it is killed
+const DefaultGrace = 5 * time.Second
+
 type status int
 
 const (
@@ -57,9 +128,12 @@ const (
 
 // Exe -
 type Exe struct {
+	// 64 bit atomics must be first to stay aligned on 32 bit platforms
+	out util.AtomicInt64
 	log *log.Log
 	lok *sync.Mutex
 	usr *user.User
+	opt ExeOpts
 	ini *sync.Once
 	sta status
 	inf Info
@@ -67,21 +141,29 @@ type Exe struct {
 	ech chan Info
 	sch chan bool
 	syn chan struct{}
+	inw *os.File
+	grp *cgroup.Group
 	ncp noCopy
 }
 
 // New -
 func New(usr *user.User) *Exe {
-	osuser := usr
+	return NewWithOpts(&ExeOpts{Osusr: usr})
+}
+
+// NewWithOpts -
+func NewWithOpts(opts *ExeOpts) *Exe {
+	osuser := opts.Osusr
 	if osuser == nil {
 		osuser, _ = user.Current()
 	}
 	return &Exe{
 		log: log.Logger(),
 		usr: osuser,
+		opt: *opts,
 		lok: &sync.Mutex{},
 		ini: &sync.Once{},
-		inf: Info{Pid: 0, Exit: -1},
+		inf: Info{Pid: 0, Exit: -1, Status: -1},
 		sta: _uninitialized,
 		ech: make(chan Info, 1),
 		sch: make(chan bool, 1),
@@ -91,23 +173,53 @@ func New(usr *user.User) *Exe {
 
 // Start -
 func (x *Exe) Start(name string, args ...string) (<-chan bool, <-chan Info) {
+	return x.StartContext(context.Background(), name, args...)
+}
+
+// StartContext - starts the program, when ctx is done before it exits the
+// process group is sent ExeOpts.Stop and killed after ExeOpts.Grace
+func (x *Exe) StartContext(ctx context.Context, name string, args ...string) (<-chan bool, <-chan Info) {
 	x.ini.Do(func() {
 		x.log.Tracef("running cmd: %s %s", name, strings.Join(args, " "))
-		go x.runf(name, args...)
+		go x.runf(ctx, name, args...)
 	})
 	return x.sch, x.ech
 }
 
 // Run -
 func (x *Exe) Run(name string, args ...string) *Info {
-	x.log.Tracef("running cmd: %s %s", name, strings.Join(args, " "))
-	_, complete := x.Start(name, args...)
+	return x.RunContext(context.Background(), name, args...)
+}
+
+// RunContext - runs the program to completion or until ctx is done, see
+// StartContext
+func (x *Exe) RunContext(ctx context.Context, name string, args ...string) *Info {
+	_, complete := x.StartContext(ctx, name, args...)
 	info := <-complete
 	return &info
 }
 
 // Terminate -
 func (x *Exe) Terminate() error {
+	return x.TerminateWith(syscall.SIGTERM)
+}
+
+// TerminateWith - terminates the process group with s instead of SIGTERM
+func (x *Exe) TerminateWith(s syscall.Signal) error {
+	x.lok.Lock()
+	defer x.lok.Unlock()
+
+	if x.sta == _uninitialized || x.inf.Finished.Get() {
+		return nil
+	}
+
+	x.sta = _signaled
+	x.inf.Signaled.Set()
+	return syscall.Kill(-x.inf.Pid, s)
+}
+
+// Kill - sends SIGKILL to the process group
+func (x *Exe) Kill() error {
 	x.lok.Lock()
 	defer x.lok.Unlock()
 
@@ -117,7 +229,14 @@ func (x *Exe) Terminate() error {
 
 	x.sta = _signaled
 	x.inf.Signaled.Set()
-	return syscall.Kill(-x.inf.Pid, syscall.SIGTERM)
+	e := syscall.Kill(-x.inf.Pid, syscall.SIGKILL)
+	if x.grp != nil {
+		// descendants that left the process group are in the cgroup
+		if err := x.grp.Kill(); err != nil {
+			x.log.Errorf("failed to kill %s, %s", x.grp.Path, err.Error())
+		}
+	}
+	return e
 }
 
 // Info -
@@ -131,12 +250,15 @@ func (x *Exe) Info() Info {
 	case _exited:
 		x.inf.Finished.Set()
 	}
+	x.inf.OutT = x.out.Get()
 	return x.inf
 }
 
 // Copy -
 func (x *Exe) Copy() *Exe {
-	return New(x.usr)
+	opts := x.opt
+	opts.Osusr = x.usr
+	return NewWithOpts(&opts)
 }
 
 // Join -
@@ -144,41 +266,179 @@ func (x *Exe) Join() <-chan struct{} {
 	return x.syn
 }
 
-func (x *Exe) runf(name string, args ...string) {
+func (x *Exe) runf(ctx context.Context, name string, args ...string) {
 	defer func() {
 		x.ech <- x.Info()
 		close(x.syn)
 	}()
 
 	cmd := x.newcmd(name, args...)
-	now := time.Now()
+	pxy, e := x.proxy(cmd)
+	if e != nil {
+		x.abort(time.Now(), e)
+		return
+	}
+
+	in, e := x.stdin(cmd, pxy)
+	// closes the parent's copies of the child's files once it has started
+	release := func() {
+		for _, p := range pxy {
+			p.release()
+		}
+		if in != nil {
+			in.Close()
+		}
+		for _, f := range cmd.ExtraFiles {
+			f.Close()
+		}
+	}
+	if e != nil {
+		release()
+		x.abort(time.Now(), e)
+		return
+	}
+
+	var grp *cgroup.Group
+	if x.opt.Cgroup != nil {
+		if grp, e = x.opt.Cgroup(); e != nil {
+			release()
+			x.abort(time.Now(), e)
+			return
+		}
+	}
+
+	// the shim changes only its own process, drinit's threads keep their
+	// limits and capabilities
+	var shm *shimrun
+	if x.opt.Limits.set() || grp != nil || x.opt.Caps.restricted() {
+		if shm, e = x.wrap(cmd, grp); e != nil {
+			release()
+			x.remove(grp)
+			x.abort(time.Now(), e)
+			return
+		}
+	}
 
-	if e := cmd.Start(); e != nil {
-		x.complete(&now, e)
-		x.sch <- false
+	var jobs chan job
+	if x.opt.Foreground && !x.opt.Pty {
+		jobs = make(chan job, 4)
+	}
+
+	now := time.Now()
+	done, e := _reaper.spawn(cmd, jobs)
+	release()
+	if e != nil {
+		if shm != nil {
+			shm.close()
+		}
+		x.remove(grp)
+		x.abort(now, e)
 		return
 	}
+	if shm != nil {
+		if e := shm.start(); e != nil {
+			// the shim exits without executing the program
+			pid := cmd.Process.Pid
+			<-done
+			cmd.Process.Release()
+			x.remove(grp)
+			x.abort(now, fmt.Errorf("failed to start pid %d, %s", pid, e.Error()))
+			return
+		}
+	}
 
-	x.init(&now, cmd)
+	x.init(&now, cmd, grp)
+	register(cmd.Process.Pid, x.opt.Role)
+	if jobs != nil {
+		go x.jobctl(jobs)
+	}
+	exited := make(chan struct{})
+	if ctx.Done() != nil {
+		go x.cancel(ctx, exited)
+	}
 	x.sch <- true
-	err := cmd.Wait()
-	x.complete(&now, err)
+	res := <-done
+	close(exited)
+	x.setstdin(nil)
+	unregister(cmd.Process.Pid)
+	if jobs != nil {
+		// the reaper stops sending jobs before the exit is sent
+		close(jobs)
+		if e := foreground(syscall.Getpgrp()); e != nil {
+			x.log.Errorf("failed to take back the terminal, %s", e.Error())
+		}
+	}
+	cmd.Process.Release()
+	x.output(pxy)
+	x.usage(grp)
+	if res.err != nil {
+		x.complete(&now, res.err)
+		return
+	}
+	x.exited(&now, res.ws, &res.ru)
 }
 
-func (x *Exe) newcmd(name string, args ...string) *exec.Cmd {
-	uid, _ := strconv.Atoi(x.usr.Uid)
-	gid, _ := strconv.Atoi(x.usr.Gid)
+// abort - the child could not be started
+func (x *Exe) abort(t time.Time, e error) {
+	x.setstdin(nil)
+	x.complete(&t, e)
+	x.sch <- false
+}
 
-	cred := &syscall.Credential{
-		Uid: uint32(uid),
-		Gid: uint32(gid),
-		NoSetGroups: true,
+// cancel - stops the program when ctx is done, it is killed if it has not
+// exited after the grace period
+func (x *Exe) cancel(ctx context.Context, exited <-chan struct{}) {
+	select {
+	case <-exited:
+		return
+	case <-ctx.Done():
+	}
+
+	stop, grace := x.opt.Stop, x.opt.Grace
+	if stop == 0 {
+		stop = syscall.SIGTERM
+	}
+	if grace <= 0 {
+		grace = DefaultGrace
+	}
+
+	pid := x.Info().Pid
+	x.log.Tracef("pid %d %s, sending %d", pid, ctx.Err().Error(), int(stop))
+	if e := x.TerminateWith(stop); e != nil {
+		x.log.Errorf("failed to stop pid %d, %s", pid, e.Error())
+	}
+
+	t := time.NewTimer(grace)
+	defer t.Stop()
+	select {
+	case <-exited:
+	case <-t.C:
+		x.log.Warnf("pid %d did not exit within %v of %s, killing", pid, grace, ctx.Err().Error())
+		if e := x.Kill(); e != nil {
+			x.log.Errorf("failed to kill pid %d, %s", pid, e.Error())
+		}
 	}
+}
 
+func (x *Exe) newcmd(name string, args ...string) *exec.Cmd {
 	cmd := exec.Command(name, args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Credential: cred,
-		Setpgid:    true,
+		Credential:  x.credential(),
+		Setpgid:     true,
+		AmbientCaps: x.opt.Caps.Ambient,
+	}
+	switch {
+	case x.opt.Pty:
+		// a session leader is its own process group, the pty on the
+		// child's stdin becomes its controlling terminal
+		cmd.SysProcAttr.Setpgid = false
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+	case x.opt.Foreground && IsTerminal(0):
+		// Ctty is the child's stdin
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = 0
 	}
 
 	cmd.Env = os.Environ()
@@ -189,15 +449,143 @@ func (x *Exe) newcmd(name string, args ...string) *exec.Cmd {
 	return cmd
 }
 
-func (x *Exe) init(t *time.Time, cmd *exec.Cmd) {
+func (x *Exe) proxy(cmd *exec.Cmd) ([]*proxy, error) {
+	if !x.opt.Proxy && x.opt.Capture <= 0 && !x.opt.Pty {
+		return nil, nil
+	}
+
+	var cap *capture
+	if x.opt.Capture > 0 {
+		cap = newcapture(x.opt.Capture)
+	}
+
+	if x.opt.Pty {
+		pty, e := newptyproxy(os.Stdout, &x.out, cap)
+		if e != nil {
+			return nil, e
+		}
+		cmd.Stdin = pty.wr
+		cmd.Stdout = pty.wr
+		cmd.Stderr = pty.wr
+		return []*proxy{pty}, nil
+	}
+
+	out, e := newproxy(os.Stdout, &x.out, cap)
+	if e != nil {
+		return nil, e
+	}
+	err, e := newproxy(os.Stderr, &x.out, cap)
+	if e != nil {
+		out.release()
+		return nil, e
+	}
+
+	cmd.Stdout = out.wr
+	cmd.Stderr = err.wr
+	return []*proxy{out, err}, nil
+}
+
+// drainwait - how long output is read after the child exits, descendants
+// may hold its stdout open
+const drainwait = time.Second
+
+// output - records the captured output once the child has exited
+func (x *Exe) output(pxy []*proxy) {
+	if x.opt.Capture <= 0 || len(pxy) == 0 {
+		return
+	}
+	// stdout and stderr share one deadline, a descendant holding both
+	// does not hold up the exit twice
+	t := time.NewTimer(drainwait)
+	defer t.Stop()
+	for _, p := range pxy {
+		if !p.drain(t.C) {
+			break
+		}
+	}
+	out, cut := pxy[0].cap.output()
+
+	x.lok.Lock()
+	defer x.lok.Unlock()
+	x.inf.Output = out
+	x.inf.Truncated = cut
+}
+
+func (x *Exe) init(t *time.Time, cmd *exec.Cmd, grp *cgroup.Group) {
 	x.lok.Lock()
 	defer x.lok.Unlock()
 
 	x.inf.Pid = cmd.Process.Pid
+	if grp != nil {
+		x.grp = grp
+		x.inf.Cgroup = grp.Path
+	}
 	x.inf.StartT = t.UnixNano()
 	x.sta = _running
 }
 
+// killwait - how long the cgroup of a killed child is given to empty
+const killwait = time.Second
+
+// usage - records what the child's cgroup used, the group is removed in
+// the background so the exit is not held up by descendants still exiting
+func (x *Exe) usage(grp *cgroup.Group) {
+	if grp == nil {
+		return
+	}
+	st := grp.Stats()
+	x.lok.Lock()
+	x.inf.Usage = &st
+	killed := x.inf.Signaled.Get()
+	x.lok.Unlock()
+	// the rest of a killed group may still be exiting
+	wait := time.Duration(0)
+	if killed {
+		wait = killwait
+	}
+	go x.cleanup(grp, wait)
+}
+
+// cleanup - removes the child's cgroup once it is empty, it is kept when
+// descendants of the child are still running in it after wait
+func (x *Exe) cleanup(grp *cgroup.Group, wait time.Duration) {
+	if !grp.Wait(wait) {
+		x.log.Tracef("cgroup %s still has processes, kept", grp.Path)
+		return
+	}
+	x.remove(grp)
+}
+
+// remove - removes the child's cgroup
+func (x *Exe) remove(grp *cgroup.Group) {
+	if grp == nil {
+		return
+	}
+	if e := grp.Remove(); e != nil {
+		x.log.Errorf("failed to remove %s", e.Error())
+	}
+}
+
+func (x *Exe) exited(t *time.Time, ws syscall.WaitStatus, ru *syscall.Rusage) {
+	x.lok.Lock()
+	x.inf.Status = ws.ExitStatus()
+	x.inf.Signal = 0
+	if ws.Signaled() {
+		x.inf.Signal = ws.Signal()
+	}
+	x.inf.Core = ws.CoreDump()
+	x.inf.Utime = time.Duration(ru.Utime.Nano())
+	x.inf.Stime = time.Duration(ru.Stime.Nano())
+	x.inf.MaxRSS = int64(ru.Maxrss)
+	x.lok.Unlock()
+
+	var err error
+	if !ws.Exited() || ws.ExitStatus() != 0 {
+		err = &ExitError{ws}
+	}
+	x.complete(t, err)
+}
+
 func (x *Exe) complete(t *time.Time, err error) {
 	code := 0
 	if err != nil {
@@ -214,6 +602,7 @@ func (x *Exe) endstate(t *time.Time, code int, err error) {
 	x.inf.Exit = code
 	x.inf.StartT = t.UnixNano()
 	x.inf.EndT = time.Now().UnixNano()
+	x.inf.Stopped.Clear()
 	if x.sta != _signaled {
 		x.inf.Finished.Set()
 		x.sta = _exited
@@ -221,12 +610,11 @@ func (x *Exe) endstate(t *time.Time, code int, err error) {
 }
 
 func exiterr(err error) int {
-	if e, ok := err.(*exec.ExitError); ok {
-		ws := e.Sys().(syscall.WaitStatus)
-		if sig := ws.Signal(); sig > 0 {
-			return int(sig)
+	if e, ok := err.(*ExitError); ok {
+		if e.Signaled() {
+			return int(e.Signal())
 		}
-		return ws.ExitStatus()
+		return e.ExitStatus()
 	}
 	return 0
 }
@@ -19,13 +19,20 @@ limitations under the License.
 package exe
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/streamz/drinit/cgroup"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -81,3 +88,351 @@ func TestTerminate(t *testing.T) {
 		info.Exit,
 		"should exit with 15")
 }
+
+func TestProxy(t *testing.T) {
+	u, _ := user.Current()
+	exc := NewWithOpts(&ExeOpts{Osusr: u, Proxy: true})
+
+	_, ctx := exc.Start(Testdata + "process.sh")
+	info := <-ctx
+
+	assert.NoError(t, info.Error)
+	assert.Equal(t, 0, info.Exit, "should exit with 0")
+	assert.NotEqual(t, int64(0), info.OutT, "output should be recorded")
+	assert.True(t, info.OutT >= info.StartT, "output should follow start")
+}
+
+func TestExitDetails(t *testing.T) {
+	u, _ := user.Current()
+
+	info := New(u).Run("/bin/sh", "-c", "exit 3")
+	assert.IsType(t, &ExitError{}, info.Error)
+	assert.Equal(t, 3, info.Exit, "should exit with 3")
+	assert.Equal(t, 3, info.Status, "status should be 3")
+	assert.Equal(t, syscall.Signal(0), info.Signal, "should not be signaled")
+	assert.True(t, info.MaxRSS > 0, "maxrss should be recorded")
+
+	info = New(u).Run("/bin/sh", "-c", "kill -KILL $$")
+	assert.EqualError(t, info.Error, "signal: killed")
+	assert.Equal(t, 9, info.Exit, "should exit with 9")
+	assert.Equal(t, -1, info.Status, "status should be -1")
+	assert.Equal(t, syscall.SIGKILL, info.Signal, "should be killed")
+	assert.False(t, info.Core, "should not dump core")
+}
+
+func TestRoles(t *testing.T) {
+	x := NewWithOpts(&ExeOpts{Role: RoleTrap})
+	started, complete := x.Start("sleep", "1")
+	assert.True(t, <-started)
+	pid := x.Info().Pid
+	assert.Equal(t, RoleTrap, Roles()[pid])
+	<-complete
+	_, ok := Roles()[pid]
+	assert.False(t, ok, "role should be dropped once the child exits")
+}
+
+func TestJobControl(t *testing.T) {
+	NewReaper().Start()
+	x := NewWithOpts(&ExeOpts{Foreground: true})
+	started, complete := x.Start("sleep", "30")
+	assert.True(t, <-started)
+	pid := x.Info().Pid
+
+	syscall.Kill(-pid, syscall.SIGSTOP)
+	time.Sleep(500 * time.Millisecond)
+	info := x.Info()
+	assert.True(t, info.Stopped.Get(), "should be stopped")
+
+	syscall.Kill(-pid, syscall.SIGCONT)
+	time.Sleep(500 * time.Millisecond)
+	info = x.Info()
+	assert.False(t, info.Stopped.Get(), "should be continued")
+
+	x.Kill()
+	info = <-complete
+	assert.Equal(t, syscall.SIGKILL, info.Signal)
+	assert.False(t, info.Stopped.Get())
+}
+
+func TestCapture(t *testing.T) {
+	x := NewWithOpts(&ExeOpts{Capture: 1024})
+	info := x.Run("sh", "-c", "echo out; echo err >&2; exit 3")
+	assert.Error(t, info.Error)
+	assert.Equal(t, 3, info.Exit)
+	assert.Contains(t, info.Output, "out\n")
+	assert.Contains(t, info.Output, "err\n")
+	assert.False(t, info.Truncated)
+
+	x = NewWithOpts(&ExeOpts{Capture: 4})
+	info = x.Run("printf", "0123456789")
+	assert.NoError(t, info.Error)
+	assert.Equal(t, "6789", info.Output, "should keep the last bytes")
+	assert.True(t, info.Truncated)
+
+	// a background child holding stdout and stderr is waited for once
+	now := time.Now()
+	x = NewWithOpts(&ExeOpts{Capture: 1024})
+	info = x.Run("sh", "-c", "sleep 3 & echo bg")
+	assert.NoError(t, info.Error)
+	assert.Equal(t, "bg\n", info.Output)
+	assert.True(t, time.Since(now) < 1500*time.Millisecond, "should drain both under one deadline")
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, can := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer can()
+	now := time.Now()
+	info := New(nil).RunContext(ctx, "sleep", "30")
+	assert.Error(t, info.Error)
+	assert.True(t, info.Signaled.Get(), "info should be Signaled")
+	assert.Equal(t, syscall.SIGTERM, info.Signal, "should stop with SIGTERM")
+	assert.True(t, time.Since(now) < 5*time.Second, "should not wait for sleep")
+
+	// stubborn.sh ignores SIGTERM
+	ctx, can = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer can()
+	now = time.Now()
+	x := NewWithOpts(&ExeOpts{Grace: 200 * time.Millisecond})
+	info = x.RunContext(ctx, Testdata+"stubborn.sh")
+	assert.Equal(t, syscall.SIGKILL, info.Signal, "should kill after the grace period")
+	assert.True(t, time.Since(now) < DefaultGrace, "should use the grace period")
+}
+
+func TestPty(t *testing.T) {
+	x := NewWithOpts(&ExeOpts{Pty: true, Capture: 1024})
+	info := x.Run("sh", "-c", "test -t 0 && test -t 1 && echo tty; stty size")
+	assert.NoError(t, info.Error)
+	assert.Contains(t, info.Output, "tty\r\n", "stdio should be the pty")
+	assert.NotContains(t, info.Output, "0 0", "the pty should have a size")
+
+	// the pty is the controlling terminal of the child's session
+	x = NewWithOpts(&ExeOpts{Pty: true, Capture: 1024})
+	info = x.Run("sh", "-c", "echo ctty > /dev/tty")
+	assert.NoError(t, info.Error)
+	assert.Contains(t, info.Output, "ctty")
+}
+
+func TestStdin(t *testing.T) {
+	x := NewWithOpts(&ExeOpts{Stdin: StdinPipe, Capture: 1024})
+	started, complete := x.Start("head", "-n", "1")
+	assert.True(t, <-started)
+	_, e := x.Write([]byte("hello\n"))
+	assert.NoError(t, e)
+	info := <-complete
+	assert.NoError(t, info.Error)
+	assert.Equal(t, "hello\n", info.Output)
+	_, e = x.Write([]byte("closed\n"))
+	assert.Error(t, e, "stdin should be closed when the program exits")
+
+	x = NewWithOpts(&ExeOpts{Stdin: StdinNull, Capture: 1024})
+	info = *x.Run("cat")
+	assert.NoError(t, info.Error)
+	assert.Equal(t, "", info.Output, "stdin should be /dev/null")
+	_, e = x.Write([]byte("hello\n"))
+	assert.Error(t, e, "stdin is not a pipe")
+
+	// a pty is written through its master
+	x = NewWithOpts(&ExeOpts{Pty: true, Stdin: StdinPipe, Capture: 1024})
+	started, complete = x.Start("sh", "-c", "read l; echo got $l")
+	assert.True(t, <-started)
+	_, e = x.Write([]byte("pty\n"))
+	assert.NoError(t, e)
+	info = <-complete
+	assert.NoError(t, info.Error)
+	assert.Contains(t, info.Output, "got pty")
+
+	for _, n := range []string{"inherit", "null", "pipe"} {
+		s, e := ToStdin(n)
+		assert.NoError(t, e)
+		assert.Equal(t, n, s.String())
+	}
+	_, e = ToStdin("tty")
+	assert.Error(t, e)
+}
+
+// asnobody - runs children from / so nobody can chdir to their directory
+func asnobody(t *testing.T) func() {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the user needs root")
+	}
+	pwd := os.Getenv("PWD")
+	os.Setenv("PWD", "/")
+	return func() { os.Setenv("PWD", pwd) }
+}
+
+func TestIdentity(t *testing.T) {
+	defer asnobody(t)()
+	u, g, e := LookupUserGroup("nobody:65534")
+	assert.NoError(t, e)
+	assert.Equal(t, "65534", g.Gid)
+
+	x := NewWithOpts(&ExeOpts{Osusr: u, Osgrp: g, Capture: 1024})
+	info := x.Run("sh", "-c", "id -u; id -g; id -G")
+	assert.NoError(t, info.Error)
+	assert.Equal(t, "65534\n65534\n65534\n", info.Output, "root's groups should not be kept")
+
+	u, g, e = LookupUserGroup("12345")
+	assert.NoError(t, e, "a uid without a passwd entry is used as is")
+	assert.Nil(t, g)
+	assert.Equal(t, "12345", u.Gid)
+	_, _, e = LookupUserGroup("no-such-user")
+	assert.Error(t, e)
+	_, _, e = LookupUserGroup("root:no-such-group")
+	assert.Error(t, e)
+}
+
+func TestCaps(t *testing.T) {
+	defer asnobody(t)()
+	nobody, _ := LookupUser("nobody")
+	bind, _ := ToCap("net_bind_service")
+	x := NewWithOpts(&ExeOpts{
+		Osusr:   nobody,
+		Capture: 1024,
+		Caps: Caps{
+			Bounding:   []uintptr{bind},
+			Ambient:    []uintptr{bind},
+			NoNewPrivs: true,
+		},
+	})
+	info := x.Run("grep", "-E", "CapBnd|CapAmb|NoNewPrivs", "/proc/self/status")
+	assert.NoError(t, info.Error)
+	assert.Contains(t, info.Output, "NoNewPrivs:\t1")
+	assert.Contains(t, info.Output, "CapBnd:\t0000000000000400")
+	assert.Contains(t, info.Output, "CapAmb:\t0000000000000400")
+
+	// the shim restricts only itself, drinit and later children are not
+	// affected
+	info = NewWithOpts(&ExeOpts{Capture: 1024}).Run("grep", "-E", "NoNewPrivs", "/proc/self/status")
+	assert.Contains(t, info.Output, "NoNewPrivs:\t0")
+	tasks, _ := filepath.Glob("/proc/self/task/*/status")
+	for _, task := range tasks {
+		b, _ := ioutil.ReadFile(task)
+		assert.NotContains(t, string(b), "NoNewPrivs:\t1", "drinit's threads should not be restricted")
+	}
+
+	caps, e := ToCaps([]string{"CAP_CHOWN,kill", "10"})
+	assert.NoError(t, e)
+	assert.Equal(t, []uintptr{0, 5, 10}, caps)
+	assert.Equal(t, "CAP_NET_BIND_SERVICE", CapToName(caps[2]))
+	caps, e = ToCaps([]string{"none"})
+	assert.NoError(t, e)
+	assert.NotNil(t, caps, "none is an empty set")
+	assert.Empty(t, caps)
+	_, e = ToCaps([]string{"CAP_FLY"})
+	assert.Error(t, e)
+}
+
+func TestLimits(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("raising limits needs root")
+	}
+	umask, nice, adj := 027, 5, 500
+	x := NewWithOpts(&ExeOpts{
+		Capture: 1024,
+		Limits: Limits{
+			Rlimits:     map[int]syscall.Rlimit{syscall.RLIMIT_NOFILE: {Cur: 1000, Max: 2000}},
+			Umask:       &umask,
+			Nice:        &nice,
+			Ioprio:      &Ioprio{Class: IoprioBE, Level: 6},
+			Affinity:    []int{0},
+			OOMScoreAdj: &adj,
+		},
+	})
+	info := x.Run("sh", "-c",
+		"echo $$; ulimit -Sn; ulimit -Hn; umask; nice; cat /proc/self/oom_score_adj; ionice; grep Cpus_allowed_list /proc/self/status")
+	assert.NoError(t, info.Error)
+	lines := strings.Split(info.Output, "\n")
+	assert.Equal(t, strconv.Itoa(info.Pid), lines[0], "the program keeps the shim's pid")
+	assert.Equal(t, []string{"1000", "2000", "0027", "5", "500", "best-effort: prio 6", "Cpus_allowed_list:\t0"}, lines[1:8])
+
+	// limits that cannot be applied fail the start
+	x = NewWithOpts(&ExeOpts{
+		Limits: Limits{Rlimits: map[int]syscall.Rlimit{syscall.RLIMIT_NOFILE: {Cur: 1 << 40, Max: 1 << 40}}},
+	})
+	started, complete := x.Start("true")
+	assert.False(t, <-started)
+	failed := <-complete
+	assert.Error(t, failed.Error)
+	assert.Contains(t, failed.Error.Error(), "rlimit nofile")
+
+	// the limits are applied before the user changes, and do not need the
+	// privileges of drinit over the program's user
+	defer asnobody(t)()
+	nobody, _ := LookupUser("nobody")
+	bind, _ := ToCap("NET_BIND_SERVICE")
+	nice = -3
+	x = NewWithOpts(&ExeOpts{
+		Osusr:   nobody,
+		Capture: 1024,
+		Caps:    Caps{Bounding: []uintptr{bind}, Ambient: []uintptr{bind}, NoNewPrivs: true},
+		Limits:  Limits{Nice: &nice, OOMScoreAdj: &adj},
+	})
+	info = x.Run("sh", "-c", "id -u; nice; cat /proc/self/oom_score_adj; grep -E 'CapAmb|CapBnd|NoNewPrivs' /proc/self/status")
+	assert.NoError(t, info.Error)
+	assert.Equal(t, "65534\n-3\n500\nCapBnd:\t0000000000000400\nCapAmb:\t0000000000000400\nNoNewPrivs:\t1\n", info.Output)
+
+	_, rl, e := ToRlimit("core=unlimited")
+	assert.NoError(t, e)
+	assert.Equal(t, RlimInfinity, rl.Max)
+	_, _, e = ToRlimit("nofile=10:5")
+	assert.Error(t, e)
+	p, e := ToIoprio("idle")
+	assert.NoError(t, e)
+	assert.Equal(t, IoprioIdle, p.Class)
+	cpus, e := ToCpus("0-2,5")
+	assert.NoError(t, e)
+	assert.Equal(t, []int{0, 1, 2, 5}, cpus)
+}
+
+func TestCgroup(t *testing.T) {
+	mnt := cgroup.Mount()
+	if len(mnt) == 0 {
+		t.Skip("cgroup v2 is not mounted")
+	}
+	dir := filepath.Join(mnt, fmt.Sprintf("drinit-exe-test-%d", os.Getpid()))
+	if e := os.Mkdir(dir, 0755); e != nil {
+		t.Skipf("cgroup v2 is not writable, %s", e.Error())
+	}
+	tree, e := cgroup.NewTree(dir)
+	assert.NoError(t, e)
+	defer func() {
+		tree.Kill(time.Second)
+		cgroup.Open(dir).Remove()
+	}()
+
+	// the child is in its group before it executes, the group is removed
+	// once the child has exited
+	x := NewWithOpts(&ExeOpts{Capture: 1024, Cgroup: tree.Hook(RoleProgram, cgroup.Limits{})})
+	info := x.Run("sh", "-c", "grep ^0:: /proc/self/cgroup; i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done")
+	assert.NoError(t, info.Error)
+	assert.Equal(t, filepath.Join(dir, "program.1"), info.Cgroup)
+	assert.Contains(t, info.Output, "/drinit-exe-test-"+strconv.Itoa(os.Getpid())+"/program.1\n")
+	if assert.NotNil(t, info.Usage) {
+		assert.True(t, info.Usage.CPU > 0)
+	}
+	assert.Eventually(t, func() bool {
+		_, e := os.Stat(info.Cgroup)
+		return os.IsNotExist(e)
+	}, 2*time.Second, 10*time.Millisecond, "the empty group should be removed")
+
+	// Kill reaches a descendant that left the process group
+	x = NewWithOpts(&ExeOpts{Cgroup: tree.Hook(RoleProgram, cgroup.Limits{})})
+	started, complete := x.Start("sh", "-c", "setsid sleep 60 & wait")
+	assert.True(t, <-started)
+	time.Sleep(100 * time.Millisecond)
+	grp := cgroup.Open(x.Info().Cgroup)
+	assert.Len(t, tree.Procs(), 2)
+	assert.NoError(t, x.Kill())
+	<-complete
+	assert.True(t, grp.Wait(time.Second))
+	assert.Eventually(t, func() bool { return len(tree.Groups()) == 0 },
+		2*time.Second, 10*time.Millisecond, "the killed group should be removed")
+
+	// a group that cannot be created fails the start
+	x = NewWithOpts(&ExeOpts{Cgroup: func() (*cgroup.Group, error) {
+		return nil, fmt.Errorf("no group")
+	}})
+	started, complete = x.Start("true")
+	assert.False(t, <-started)
+	assert.EqualError(t, (<-complete).Error, "no group")
+}
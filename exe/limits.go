@@ -0,0 +1,302 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Limits - the resource limits and scheduling attributes of a child, they
+// are applied by the shim before the program is executed
+type Limits struct {
+	// Rlimits - resource limits by RLIMIT_ resource
+	Rlimits map[int]syscall.Rlimit
+	// Umask - the file mode creation mask, nil keeps drinit's
+	Umask *int
+	// Nice - the nice value, nil keeps drinit's
+	Nice *int
+	// Ioprio - the io scheduling class and level, nil keeps drinit's
+	Ioprio *Ioprio
+	// Affinity - the cpus the child may run on, empty keeps drinit's
+	Affinity []int
+	// OOMScoreAdj - the oom killer adjustment, -1000 to 1000, nil keeps
+	// drinit's
+	OOMScoreAdj *int
+}
+
+func (l Limits) String() string {
+	var s []string
+	for r, rl := range l.Rlimits {
+		s = append(s, fmt.Sprintf("%s=%s:%s", RlimitToName(r), rlimitstring(rl.Cur), rlimitstring(rl.Max)))
+	}
+	sort.Strings(s)
+	if l.Umask != nil {
+		s = append(s, fmt.Sprintf("umask=%04o", *l.Umask))
+	}
+	if l.Nice != nil {
+		s = append(s, fmt.Sprintf("nice=%d", *l.Nice))
+	}
+	if l.Ioprio != nil {
+		s = append(s, "ioprio="+l.Ioprio.String())
+	}
+	if len(l.Affinity) > 0 {
+		s = append(s, fmt.Sprintf("cpus=%v", l.Affinity))
+	}
+	if l.OOMScoreAdj != nil {
+		s = append(s, fmt.Sprintf("oom_score_adj=%d", *l.OOMScoreAdj))
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// Ioprio - an io scheduling class and its level, 0 (highest) to 7
+type Ioprio struct {
+	Class, Level int
+}
+
+// io scheduling classes
+const (
+	IoprioRT   = 1
+	IoprioBE   = 2
+	IoprioIdle = 3
+)
+
+var ioprio2name = map[int]string{
+	IoprioRT:   "rt",
+	IoprioBE:   "be",
+	IoprioIdle: "idle",
+}
+
+func (p Ioprio) String() string {
+	return fmt.Sprintf("%s:%d", ioprio2name[p.Class], p.Level)
+}
+
+// RlimInfinity - no limit
+const RlimInfinity = ^uint64(0)
+
+var rlimit2name = map[int]string{
+	0:  "cpu",
+	1:  "fsize",
+	2:  "data",
+	3:  "stack",
+	4:  "core",
+	5:  "rss",
+	6:  "nproc",
+	7:  "nofile",
+	8:  "memlock",
+	9:  "as",
+	10: "locks",
+	11: "sigpending",
+	12: "msgqueue",
+	13: "nice",
+	14: "rtprio",
+	15: "rttime",
+}
+
+// RlimitToName - the name of a resource, ie. nofile
+func RlimitToName(r int) string {
+	if name, ok := rlimit2name[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("rlimit(%d)", r)
+}
+
+// ToRlimit - parses name=soft[:hard], ie. nofile=1024:4096 or
+// core=unlimited, a single value sets both limits
+func ToRlimit(spec string) (int, syscall.Rlimit, error) {
+	var rl syscall.Rlimit
+	name, val, ok := cut(spec, "=")
+	if !ok {
+		return 0, rl, fmt.Errorf("invalid rlimit: %s, expected name=soft[:hard]", spec)
+	}
+	res := -1
+	for r, n := range rlimit2name {
+		if strings.EqualFold(n, strings.TrimPrefix(strings.ToLower(name), "rlimit_")) {
+			res = r
+		}
+	}
+	if res < 0 {
+		return 0, rl, fmt.Errorf("invalid rlimit: %s", name)
+	}
+
+	soft, hard, ok := cut(val, ":")
+	if !ok {
+		hard = soft
+	}
+	var e error
+	if rl.Cur, e = rlimitvalue(soft); e != nil {
+		return 0, rl, fmt.Errorf("invalid rlimit: %s, %s", spec, e.Error())
+	}
+	if rl.Max, e = rlimitvalue(hard); e != nil {
+		return 0, rl, fmt.Errorf("invalid rlimit: %s, %s", spec, e.Error())
+	}
+	if rl.Cur > rl.Max {
+		return 0, rl, fmt.Errorf("invalid rlimit: %s, the soft limit is above the hard limit", spec)
+	}
+	return res, rl, nil
+}
+
+func rlimitstring(v uint64) string {
+	if v == RlimInfinity {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+func rlimitvalue(s string) (uint64, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "unlimited", "infinity", "-1":
+		return RlimInfinity, nil
+	}
+	return strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+}
+
+// ToIoprio - parses class[:level], ie. be:4, rt:0 or idle
+func ToIoprio(spec string) (Ioprio, error) {
+	name, level, ok := cut(spec, ":")
+	p := Ioprio{Class: -1}
+	for c, n := range ioprio2name {
+		if strings.EqualFold(n, name) {
+			p.Class = c
+		}
+	}
+	if p.Class < 0 {
+		return p, fmt.Errorf("invalid io class: %s, expected rt, be or idle", name)
+	}
+	if ok {
+		l, e := strconv.Atoi(level)
+		if e != nil || l < 0 || l > 7 {
+			return p, fmt.Errorf("invalid io priority level: %s, expected 0 to 7", level)
+		}
+		p.Level = l
+	}
+	return p, nil
+}
+
+// ToCpus - parses a cpu list, ie. 0-3,6
+func ToCpus(spec string) ([]int, error) {
+	var cpus []int
+	for _, r := range strings.Split(spec, ",") {
+		lo, hi, ok := cut(strings.TrimSpace(r), "-")
+		if !ok {
+			hi = lo
+		}
+		l, e1 := strconv.Atoi(lo)
+		h, e2 := strconv.Atoi(hi)
+		if e1 != nil || e2 != nil || l < 0 || h < l || h >= cpusetsize*64 {
+			return nil, fmt.Errorf("invalid cpu list: %s", spec)
+		}
+		for c := l; c <= h; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
+
+func cut(s, sep string) (string, string, bool) {
+	if n := strings.Index(s, sep); n >= 0 {
+		return s[:n], s[n+len(sep):], true
+	}
+	return s, "", false
+}
+
+// set - limits are applied to the child
+func (l *Limits) set() bool {
+	return len(l.Rlimits) > 0 || l.Umask != nil || l.Nice != nil ||
+		l.Ioprio != nil || len(l.Affinity) > 0 || l.OOMScoreAdj != nil
+}
+
+// apply - applies the limits to the calling thread and its process, the
+// nice value, io priority and affinity are per thread on linux and are
+// inherited by the program the thread executes
+func (l *Limits) apply() error {
+	if l.Umask != nil {
+		syscall.Umask(*l.Umask)
+	}
+	for r, rl := range l.Rlimits {
+		if e := prlimit(0, r, &rl); e != nil {
+			return fmt.Errorf("rlimit %s, %s", RlimitToName(r), e.Error())
+		}
+	}
+	if l.Nice != nil {
+		if e := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *l.Nice); e != nil {
+			return fmt.Errorf("nice %d, %s", *l.Nice, e.Error())
+		}
+	}
+	if l.Ioprio != nil {
+		if e := ioprioset(0, *l.Ioprio); e != nil {
+			return fmt.Errorf("ioprio %s, %s", l.Ioprio.String(), e.Error())
+		}
+	}
+	if len(l.Affinity) > 0 {
+		if e := setaffinity(0, l.Affinity); e != nil {
+			return fmt.Errorf("cpu affinity %v, %s", l.Affinity, e.Error())
+		}
+	}
+	if l.OOMScoreAdj != nil {
+		adj := []byte(strconv.Itoa(*l.OOMScoreAdj))
+		if e := ioutil.WriteFile("/proc/self/oom_score_adj", adj, 0); e != nil {
+			return fmt.Errorf("oom_score_adj %d, %s", *l.OOMScoreAdj, e.Error())
+		}
+	}
+	return nil
+}
+
+func prlimit(pid, resource int, rl *syscall.Rlimit) error {
+	_, _, e := syscall.RawSyscall6(
+		syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(rl)), 0, 0, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// ioprio_set(IOPRIO_WHO_PROCESS, ...)
+const _IOPRIO_WHO_PROCESS = 1
+
+func ioprioset(pid int, p Ioprio) error {
+	prio := p.Class<<13 | p.Level
+	_, _, e := syscall.RawSyscall(
+		syscall.SYS_IOPRIO_SET, _IOPRIO_WHO_PROCESS, uintptr(pid), uintptr(prio))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// cpusetsize - the number of 64 bit words in a cpu mask, 1024 cpus
+const cpusetsize = 16
+
+func setaffinity(pid int, cpus []int) error {
+	var mask [cpusetsize]uint64
+	for _, c := range cpus {
+		mask[c/64] |= 1 << uint(c%64)
+	}
+	_, _, e := syscall.RawSyscall(
+		syscall.SYS_SCHED_SETAFFINITY, uintptr(pid), uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"os"
+	"time"
+
+	"github.com/streamz/drinit/util"
+)
+
+// proxy - copies a child's output to one of drinit's own streams,
+// recording the time of the last write and capturing it if asked to
+type proxy struct {
+	rd  *os.File
+	wr  *os.File
+	dst *os.File
+	act *util.AtomicInt64
+	cap *capture
+	eof chan struct{}
+}
+
+func newproxy(dst *os.File, act *util.AtomicInt64, cap *capture) (*proxy, error) {
+	r, w, e := os.Pipe()
+	if e != nil {
+		return nil, e
+	}
+	p := &proxy{
+		rd:  r,
+		wr:  w,
+		dst: dst,
+		act: act,
+		cap: cap,
+		eof: make(chan struct{}),
+	}
+	go p.copy()
+	return p, nil
+}
+
+// release - closes the parent's copy of the write end, once the child
+// has started (or failed to start) the reader sees EOF when the child
+// and all of its descendants have closed the pipe
+func (p *proxy) release() {
+	p.wr.Close()
+}
+
+// drain - waits for the copy to reach EOF, descendants that still hold
+// the pipe open are not waited for past the deadline
+func (p *proxy) drain(deadline <-chan time.Time) bool {
+	select {
+	case <-p.eof:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
+func (p *proxy) copy() {
+	defer close(p.eof)
+	defer p.rd.Close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, e := p.rd.Read(buf)
+		if n > 0 {
+			p.act.Set(time.Now().UnixNano())
+			p.dst.Write(buf[:n])
+			if p.cap != nil {
+				p.cap.Write(buf[:n])
+			}
+		}
+		if e != nil {
+			return
+		}
+	}
+}
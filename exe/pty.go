@@ -0,0 +1,140 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"github.com/streamz/drinit/util"
+)
+
+// winsize - struct winsize from the TIOCGWINSZ ioctl
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+// the size given to a pty when drinit has no terminal to copy it from
+const (
+	DefaultRows = 24
+	DefaultCols = 80
+)
+
+// openpty - allocates a pseudo-terminal, returns its master and slave
+func openpty() (*os.File, *os.File, error) {
+	ptm, e := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	var n uint32
+	if e = fioctl(ptm, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&n))); e != nil {
+		ptm.Close()
+		return nil, nil, fmt.Errorf("unlockpt, %s", e.Error())
+	}
+	if e = fioctl(ptm, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); e != nil {
+		ptm.Close()
+		return nil, nil, fmt.Errorf("ptsname, %s", e.Error())
+	}
+
+	pts, e := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if e != nil {
+		ptm.Close()
+		return nil, nil, e
+	}
+	return ptm, pts, nil
+}
+
+// newptyproxy - a proxy reading the output of a pty, the slave is the
+// write end and is given to the child as its stdio
+func newptyproxy(dst *os.File, act *util.AtomicInt64, cap *capture) (*proxy, error) {
+	ptm, pts, e := openpty()
+	if e != nil {
+		return nil, e
+	}
+	p := &proxy{
+		rd:  ptm,
+		wr:  pts,
+		dst: dst,
+		act: act,
+		cap: cap,
+		eof: make(chan struct{}),
+	}
+	resize(ptm)
+	go winch(ptm, p.eof)
+	// reading the master fails with EIO once every slave is closed
+	go p.copy()
+	return p, nil
+}
+
+// resize - copies the size of drinit's terminal to the pty, the default
+// size is used when drinit has no terminal
+func resize(ptm *os.File) {
+	ws := winsize{Row: DefaultRows, Col: DefaultCols}
+	for _, fd := range []uintptr{1, 0, 2} {
+		var t winsize
+		if ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&t))) == nil && t.Row > 0 {
+			ws = t
+			break
+		}
+	}
+	fioctl(ptm, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// winch - resizes the pty when drinit's terminal is resized, the kernel
+// sends SIGWINCH to the child when the size changes
+func winch(ptm *os.File, done <-chan struct{}) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGWINCH)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-c:
+			resize(ptm)
+		case <-done:
+			return
+		}
+	}
+}
+
+// fioctl - ioctl on an os.File without Fd, which would put it in blocking
+// mode
+func fioctl(f *os.File, req, arg uintptr) error {
+	c, e := f.SyscallConn()
+	if e != nil {
+		return e
+	}
+	var err error
+	if e = c.Control(func(fd uintptr) { err = ioctl(fd, req, arg) }); e != nil {
+		return e
+	}
+	return err
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
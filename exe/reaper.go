@@ -21,25 +21,75 @@ package exe
 
 import (
 	"os"
+	"os/exec"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
+	"unsafe"
 
 	"github.com/streamz/drinit/log"
+	"github.com/streamz/drinit/proc"
+	"github.com/streamz/drinit/util"
 )
 
-// Reaper - a zombie process reaper
+// recent - the number of orphans kept for reporting
+const recent = 32
+
+// Orphan - a child reaped without a registered waiter
+type Orphan struct {
+	Pid int
+	// Comm - the command name, an orphan is a zombie by the time it is
+	// seen and a zombie has no command line
+	Comm string
+	// Status - the exit status, -1 if the orphan was killed by a signal
+	Status int
+	Signal syscall.Signal
+	Utime  time.Duration
+	Stime  time.Duration
+	MaxRSS int64
+	// ReapT - when the orphan was reaped
+	ReapT int64
+}
+
+// ReaperStats - reaper counters and the most recently reaped orphans
+type ReaperStats struct {
+	// Reaped - every child reaped, Orphans - the unclaimed ones
+	Reaped, Orphans int
+	// LastMinute - orphans reaped in the last minute
+	LastMinute int
+	Recent     []Orphan
+}
+
+// Reaper - a zombie process reaper, the reaper owns every wait for a child
+// of drinit, exit statuses are handed to the waiter registered for the pid
+// and unclaimed pids are reaped as orphans
 type Reaper struct {
+	// 64 bit atomics must be first to stay aligned on 32 bit platforms
+	rpd util.AtomicInt
 	log *log.Log
 	one sync.Once
+	run util.AtomicBool
+	lok sync.Mutex
+	wtr map[int]chan exit
+	jbs map[int]chan<- job
+	slk sync.Mutex
+	orp []Orphan
+	min []int64
+	cnt int
+}
+
+// there can only be one wait4(-1) loop per process
+var _reaper = &Reaper{
+	log: log.Logger(),
+	one: sync.Once{},
+	wtr: make(map[int]chan exit),
+	jbs: make(map[int]chan<- job),
 }
 
-// NewReaper - Constructor for a zombie process reaper
+// NewReaper - returns the process wide zombie process reaper
 func NewReaper() *Reaper {
-	return &Reaper{
-		log: log.Logger(),
-		one: sync.Once{},
-	}
+	return _reaper
 }
 
 func (r *Reaper) sigchldH(notifier chan os.Signal) {
@@ -55,9 +105,11 @@ func (r *Reaper) sigchldH(notifier chan os.Signal) {
 	}
 }
 
-// Start - Start the reaper goroutine
+// Start - Start the reaper goroutine, programs started before the reaper
+// wait for themselves
 func (r *Reaper) Start() {
 	r.one.Do(func() {
+		r.run.Set()
 		go r.reap()
 	})
 }
@@ -66,28 +118,183 @@ func (r *Reaper) reap() {
 	notifier := make(chan os.Signal, 1)
 	go r.sigchldH(notifier)
 
-	pid := -1
-	opt := 0
-
 	for {
 		sig := <-notifier
 		r.log.Tracef("received signal %s", sig)
+		r.sweep()
+	}
+}
 
-		for {
-			var wstatus syscall.WaitStatus
-			var rusage syscall.Rusage
+// sweep - reaps every child that has exited, SIGCHLD is coalesced so one
+// signal may stand for many children
+func (r *Reaper) sweep() {
+	r.lok.Lock()
+	defer r.lok.Unlock()
 
-			pid, err := syscall.Wait4(pid, &wstatus, opt, &rusage)
+	for {
+		// peek without reaping, so an orphan's /proc entry is still there
+		pid, err := peek()
+		if err == syscall.EINTR {
+			continue
+		}
+		// ECHILD, or children that are still running
+		if err != nil || pid <= 0 {
+			break
+		}
 
-			for err == syscall.EINTR {
-				pid, err = syscall.Wait4(pid, &wstatus, opt, &rusage)
-			}
+		ch, claimed := r.wtr[pid]
+		comm := ""
+		if !claimed {
+			comm = proc.Comm(pid)
+		}
 
-			if err == syscall.ECHILD {
-				break
-			}
+		x := wait(pid)
+		if x.err != nil {
+			r.log.Errorf("reap: pid=%d, %s", pid, x.err.Error())
+			continue
+		}
+		r.rpd.Incr()
+
+		if claimed {
+			delete(r.wtr, pid)
+			delete(r.jbs, pid)
+			ch <- x
+			continue
+		}
+
+		r.orphan(pid, comm, &x)
+	}
 
-			r.log.Tracef("reap: pid=%d, wstatus=%+v, rusage=%v\n", pid, wstatus, rusage)
+	// SIGCHLD is also sent when a child is stopped or continued
+	for pid, jobs := range r.jbs {
+		if j, ok := jobstate(pid); ok {
+			select {
+			case jobs <- j:
+			default:
+			}
 		}
 	}
 }
+
+func (r *Reaper) orphan(pid int, comm string, x *exit) {
+	o := Orphan{
+		Pid:    pid,
+		Comm:   comm,
+		Status: x.ws.ExitStatus(),
+		Utime:  time.Duration(x.ru.Utime.Nano()),
+		Stime:  time.Duration(x.ru.Stime.Nano()),
+		MaxRSS: int64(x.ru.Maxrss),
+		ReapT:  time.Now().UnixNano(),
+	}
+	if x.ws.Signaled() {
+		o.Signal = x.ws.Signal()
+	}
+
+	r.log.Tracef("reap: orphan pid=%d, comm=%s, wstatus=%+v, rusage=%v", pid, comm, x.ws, x.ru)
+
+	r.slk.Lock()
+	defer r.slk.Unlock()
+
+	r.cnt++
+	r.orp = append(r.orp, o)
+	if over := len(r.orp) - recent; over > 0 {
+		r.orp = append([]Orphan(nil), r.orp[over:]...)
+	}
+	r.min = append(prune(r.min, o.ReapT), o.ReapT)
+}
+
+// Stats - returns the reaper's counters and recently reaped orphans
+func (r *Reaper) Stats() ReaperStats {
+	r.slk.Lock()
+	defer r.slk.Unlock()
+
+	r.min = prune(r.min, time.Now().UnixNano())
+	return ReaperStats{
+		Reaped:     r.rpd.Get(),
+		Orphans:    r.cnt,
+		LastMinute: len(r.min),
+		Recent:     append([]Orphan(nil), r.orp...),
+	}
+}
+
+// prune - drops reap times older than a minute
+func prune(times []int64, now int64) []int64 {
+	cut := now - int64(time.Minute)
+	n := 0
+	for n < len(times) && times[n] < cut {
+		n++
+	}
+	return times[n:]
+}
+
+// prctl options
+const (
+	_PR_SET_CHILD_SUBREAPER = 36
+	_PR_GET_CHILD_SUBREAPER = 37
+)
+
+// SetSubreaper - marks drinit as a child subreaper, orphaned descendants
+// are reparented to drinit instead of PID 1 so the reaper can reap them
+func SetSubreaper() error {
+	_, _, e := syscall.RawSyscall(syscall.SYS_PRCTL, _PR_SET_CHILD_SUBREAPER, 1, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// IsSubreaper - returns true if drinit is a child subreaper
+func IsSubreaper() (bool, error) {
+	var flag int32
+	_, _, e := syscall.RawSyscall(
+		syscall.SYS_PRCTL, _PR_GET_CHILD_SUBREAPER, uintptr(unsafe.Pointer(&flag)), 0)
+	if e != 0 {
+		return false, e
+	}
+	return flag != 0, nil
+}
+
+// spawn - starts cmd and returns a channel that receives its exit, the
+// pid is claimed before the reaper can see it, and its stops and
+// continues are sent to jobs if jobs is not nil
+func (r *Reaper) spawn(cmd *exec.Cmd, jobs chan<- job) (<-chan exit, error) {
+	ch := make(chan exit, 1)
+
+	// sweeps hold the lock, so the child cannot be reaped as an orphan
+	// between starting and registering
+	r.lok.Lock()
+	defer r.lok.Unlock()
+
+	if e := cmd.Start(); e != nil {
+		return nil, e
+	}
+	pid := cmd.Process.Pid
+	r.wtr[pid] = ch
+	if jobs != nil {
+		r.jbs[pid] = jobs
+	}
+
+	// the waiter is registered either way, so a reaper started meanwhile
+	// claims the child instead of taking it for an orphan
+	if !r.run.Get() {
+		go r.waitfor(pid, ch)
+	}
+	return ch, nil
+}
+
+// waitfor - waits for a child spawned while the reaper was not running,
+// whichever of it and a sweep reaps the child delivers its exit
+func (r *Reaper) waitfor(pid int, ch chan exit) {
+	x := wait(pid)
+
+	r.lok.Lock()
+	defer r.lok.Unlock()
+
+	// the sweep reaped it, this wait failed with ECHILD
+	if c, ok := r.wtr[pid]; !ok || c != ch {
+		return
+	}
+	delete(r.wtr, pid)
+	delete(r.jbs, pid)
+	ch <- x
+}
@@ -17,3 +17,88 @@ limitations under the License.
 */
 
 package exe
+
+import (
+	"fmt"
+	"os/user"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adopt orphans so that the reaper sees unclaimed pids
+func adopt() {
+	SetSubreaper()
+	NewReaper().Start()
+}
+
+func TestSubreaper(t *testing.T) {
+	assert.NoError(t, SetSubreaper())
+	sub, err := IsSubreaper()
+	assert.NoError(t, err)
+	assert.True(t, sub, "should be a subreaper")
+}
+
+func TestReaperStress(t *testing.T) {
+	adopt()
+
+	u, _ := user.Current()
+	w := &sync.WaitGroup{}
+	n := 200
+
+	for k := 0; k < n; k++ {
+		w.Add(2)
+		go func(k int) {
+			defer w.Done()
+			script := fmt.Sprintf("exit %d", k%100)
+			info := New(u).Run("/bin/sh", "-c", script)
+			assert.Equal(t, k%100, info.Status, "exit status should not be lost")
+			if k%100 != 0 {
+				assert.IsType(t, &ExitError{}, info.Error)
+			} else {
+				assert.NoError(t, info.Error)
+			}
+		}(k)
+		go func() {
+			defer w.Done()
+			// leaves an orphan behind for the reaper
+			info := New(u).Run("/bin/sh", "-c", "/bin/sleep 0.01 & exit 0")
+			assert.NoError(t, info.Error)
+		}()
+	}
+	w.Wait()
+}
+
+func TestSiginfo(t *testing.T) {
+	// waitid writes a whole siginfo_t whatever the architecture
+	assert.Equal(t, uintptr(128), unsafe.Sizeof(siginfo{}))
+}
+
+func TestOrphanStats(t *testing.T) {
+	adopt()
+	before := NewReaper().Stats()
+
+	u, _ := user.Current()
+	info := New(u).Run("/bin/sh", "-c", "/bin/sh -c 'sleep 0.1; exit 7' & exit 0")
+	assert.NoError(t, info.Error)
+	time.Sleep(500 * time.Millisecond)
+
+	after := NewReaper().Stats()
+	assert.True(t, after.Orphans > before.Orphans, "should reap the orphan")
+	assert.True(t, after.Reaped >= before.Reaped+2, "should count the program and the orphan")
+	assert.True(t, after.LastMinute >= 1, "should count the orphan in the last minute")
+
+	var found *Orphan
+	for n := range after.Recent {
+		if after.Recent[n].Status == 7 {
+			found = &after.Recent[n]
+		}
+	}
+	if assert.NotNil(t, found, "should record the orphan") {
+		assert.Equal(t, "sh", found.Comm)
+		assert.NotEqual(t, int64(0), found.ReapT)
+	}
+}
@@ -0,0 +1,64 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import "sync"
+
+// roles - what a child started through exe is for
+const (
+	// RoleProgram - the supervised program
+	RoleProgram = "program"
+	// RoleTrap - a script run for a trapped signal
+	RoleTrap = "trap"
+	// RoleScript - a script run by drinitctl up or down
+	RoleScript = "script"
+)
+
+// running - the role of every running child started through exe, by pid
+var running = struct {
+	sync.Mutex
+	pids map[int]string
+}{pids: make(map[int]string)}
+
+// Roles - returns the role of every running child started through exe
+func Roles() map[int]string {
+	running.Lock()
+	defer running.Unlock()
+
+	roles := make(map[int]string, len(running.pids))
+	for pid, role := range running.pids {
+		roles[pid] = role
+	}
+	return roles
+}
+
+func register(pid int, role string) {
+	if len(role) == 0 {
+		return
+	}
+	running.Lock()
+	running.pids[pid] = role
+	running.Unlock()
+}
+
+func unregister(pid int) {
+	running.Lock()
+	delete(running.pids, pid)
+	running.Unlock()
+}
@@ -0,0 +1,249 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/streamz/drinit/cgroup"
+)
+
+// shimname - argv[0] of drinit re-executed as a child that applies its
+// limits and identity to itself before executing the program, the pid
+// does not change so the program is supervised and signaled as if it ran
+// directly
+const shimname = "drinit-exec"
+
+// shimspec - what the shim applies before executing the program, read
+// from fd 3, an error is written to fd 4 which is closed by the exec
+type shimspec struct {
+	Cgroup string
+	Path   string
+	Argv   []string
+	Limits Limits
+	Caps   Caps
+	Cred   *syscall.Credential
+}
+
+func init() {
+	if len(os.Args) != 1 || os.Args[0] != shimname {
+		return
+	}
+	// per thread attributes are set on the main thread, which must be the
+	// one executing the program
+	runtime.LockOSThread()
+	status := os.NewFile(4, "status")
+	e := shim(os.NewFile(3, "spec"))
+	status.WriteString(e.Error())
+	os.Exit(126)
+}
+
+// shim - applies the spec and executes the program, returns only if it
+// failed, the limits are applied while the shim runs as drinit's user so
+// raising them does not depend on the program's user
+func shim(spec *os.File) error {
+	syscall.CloseOnExec(4)
+	b, e := ioutil.ReadAll(spec)
+	spec.Close()
+	if e != nil {
+		return e
+	}
+	var s shimspec
+	if e = json.Unmarshal(b, &s); e != nil {
+		return e
+	}
+
+	// the program is in its cgroup before it can fork
+	if len(s.Cgroup) > 0 {
+		if e = cgroup.Open(s.Cgroup).Add(0); e != nil {
+			return e
+		}
+	}
+	if e = s.Limits.apply(); e != nil {
+		return e
+	}
+	// the bounding set is dropped while the shim is still privileged
+	if e = s.Caps.restrict(); e != nil {
+		return e
+	}
+	if s.Cred != nil {
+		if e = setcred(s.Cred, s.Caps.Ambient); e != nil {
+			return e
+		}
+	}
+	e = syscall.Exec(s.Path, s.Argv, os.Environ())
+	return fmt.Errorf("exec %s, %s", s.Path, e.Error())
+}
+
+// shimrun - a child started through the shim, the parent's ends of the
+// spec and status pipes
+type shimrun struct {
+	spec    shimspec
+	specw   *os.File
+	statusr *os.File
+}
+
+// wrap - runs cmd through the shim, the child's credentials and
+// capabilities are set by the shim instead of the fork, the shim moves
+// itself into grp when it is not nil
+func (x *Exe) wrap(cmd *exec.Cmd, grp *cgroup.Group) (*shimrun, error) {
+	if cmd.Err != nil {
+		// the program was not found, Start fails
+		return nil, nil
+	}
+	specr, specw, e := os.Pipe()
+	if e != nil {
+		return nil, e
+	}
+	statusr, statusw, e := os.Pipe()
+	if e != nil {
+		specr.Close()
+		specw.Close()
+		return nil, e
+	}
+
+	run := &shimrun{
+		spec: shimspec{
+			Path:   cmd.Path,
+			Argv:   cmd.Args,
+			Limits: x.opt.Limits,
+			Caps:   x.opt.Caps,
+			Cred:   cmd.SysProcAttr.Credential,
+		},
+		specw:   specw,
+		statusr: statusr,
+	}
+	if grp != nil {
+		run.spec.Cgroup = grp.Path
+	}
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = []string{shimname}
+	cmd.SysProcAttr.Credential = nil
+	cmd.SysProcAttr.AmbientCaps = nil
+	// closed by the parent once the shim has started
+	cmd.ExtraFiles = []*os.File{specr, statusw}
+	return run, nil
+}
+
+// start - sends the spec to the shim and waits for it to execute the
+// program, returns the shim's error if it could not
+func (r *shimrun) start() error {
+	defer r.statusr.Close()
+
+	b, e := json.Marshal(&r.spec)
+	if e == nil {
+		_, e = r.specw.Write(b)
+	}
+	r.specw.Close()
+	if e != nil {
+		return e
+	}
+
+	// EOF without a message, the status pipe was closed by the exec
+	msg, e := ioutil.ReadAll(r.statusr)
+	if e != nil {
+		return e
+	}
+	if len(msg) > 0 {
+		return errors.New(string(msg))
+	}
+	return nil
+}
+
+// close - the shim could not be started
+func (r *shimrun) close() {
+	r.specw.Close()
+	r.statusr.Close()
+}
+
+// capability sets, see capget(2)
+const (
+	_LINUX_CAPABILITY_VERSION_3 = 0x20080522
+	_PR_SET_KEEPCAPS            = 8
+	_PR_CAP_AMBIENT             = 47
+	_PR_CAP_AMBIENT_RAISE       = 2
+)
+
+type capheader struct {
+	version uint32
+	pid     int32
+}
+
+type capdata struct {
+	effective, permitted, inheritable uint32
+}
+
+// setcred - switches to the program's user, keeping the ambient
+// capabilities, as the fork does for a child started without the shim
+func setcred(cred *syscall.Credential, ambient []uintptr) error {
+	if len(ambient) > 0 {
+		if e := prctl(_PR_SET_KEEPCAPS, 1); e != nil {
+			return fmt.Errorf("keepcaps, %s", e.Error())
+		}
+	}
+	if !cred.NoSetGroups {
+		gids := make([]int, len(cred.Groups))
+		for n, g := range cred.Groups {
+			gids[n] = int(g)
+		}
+		if e := syscall.Setgroups(gids); e != nil {
+			return fmt.Errorf("setgroups, %s", e.Error())
+		}
+	}
+	if e := syscall.Setgid(int(cred.Gid)); e != nil {
+		return fmt.Errorf("setgid %d, %s", cred.Gid, e.Error())
+	}
+	if e := syscall.Setuid(int(cred.Uid)); e != nil {
+		return fmt.Errorf("setuid %d, %s", cred.Uid, e.Error())
+	}
+	if len(ambient) == 0 {
+		return nil
+	}
+
+	hdr := capheader{version: _LINUX_CAPABILITY_VERSION_3}
+	var data [2]capdata
+	if _, _, e := syscall.RawSyscall(
+		syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); e != 0 {
+		return fmt.Errorf("capget, %s", e.Error())
+	}
+	// an ambient capability must be permitted and inheritable
+	for _, c := range ambient {
+		data[c/32].permitted |= 1 << (c % 32)
+		data[c/32].inheritable |= 1 << (c % 32)
+	}
+	if _, _, e := syscall.RawSyscall(
+		syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); e != 0 {
+		return fmt.Errorf("capset, %s", e.Error())
+	}
+	for _, c := range ambient {
+		if _, _, e := syscall.RawSyscall6(
+			syscall.SYS_PRCTL, _PR_CAP_AMBIENT, _PR_CAP_AMBIENT_RAISE, c, 0, 0, 0); e != 0 {
+			return fmt.Errorf("failed to raise %s, %s", CapToName(c), e.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+// +build linux,mips linux,mipsle
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+// siginfo - the SIGCHLD fields of siginfo_t, mips swaps si_code and
+// si_errno
+type siginfo struct {
+	Signo  int32
+	Code   int32
+	Errno  int32
+	Pid    int32
+	Uid    uint32
+	Status int32
+	_      [104]byte
+}
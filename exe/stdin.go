@@ -0,0 +1,140 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Stdin - what a child reads from
+type Stdin int
+
+const (
+	// StdinInherit - the child reads drinit's stdin
+	StdinInherit Stdin = iota
+	// StdinNull - the child reads /dev/null
+	StdinNull
+	// StdinPipe - the child reads a pipe written with Exe.Write
+	StdinPipe
+)
+
+var stdin2name = map[Stdin]string{
+	StdinInherit: "inherit",
+	StdinNull:    "null",
+	StdinPipe:    "pipe",
+}
+
+func (s Stdin) String() string {
+	if name, ok := stdin2name[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("stdin(%d)", int(s))
+}
+
+// ToStdin - string to Stdin
+func ToStdin(name string) (Stdin, error) {
+	for s, n := range stdin2name {
+		if n == name {
+			return s, nil
+		}
+	}
+	return StdinInherit, fmt.Errorf("invalid stdin mode: %s", name)
+}
+
+// stdinwait - how long Write waits for a child that is not reading
+const stdinwait = time.Second
+
+// Write - writes to the child's stdin, the child must have been started
+// with StdinPipe
+func (x *Exe) Write(b []byte) (int, error) {
+	x.lok.Lock()
+	w := x.inw
+	x.lok.Unlock()
+
+	if w == nil {
+		if x.opt.Stdin != StdinPipe {
+			return 0, fmt.Errorf("stdin is %s, not a pipe", x.opt.Stdin)
+		}
+		return 0, errors.New("stdin is closed, the program is not running")
+	}
+	w.SetWriteDeadline(time.Now().Add(stdinwait))
+	return w.Write(b)
+}
+
+// stdin - sets up the child's stdin, the returned read end is closed once
+// the child has started, a pty is the child's stdin in every mode and is
+// written through its master
+func (x *Exe) stdin(cmd *exec.Cmd, pxy []*proxy) (*os.File, error) {
+	switch {
+	case x.opt.Pty:
+		if x.opt.Stdin == StdinPipe {
+			w, e := dup(pxy[0].rd)
+			if e != nil {
+				return nil, e
+			}
+			x.setstdin(w)
+		}
+	case x.opt.Stdin == StdinNull:
+		// exec opens /dev/null for a nil Stdin
+		cmd.Stdin = nil
+	case x.opt.Stdin == StdinPipe:
+		r, w, e := os.Pipe()
+		if e != nil {
+			return nil, e
+		}
+		cmd.Stdin = r
+		x.setstdin(w)
+		return r, nil
+	}
+	return nil, nil
+}
+
+// setstdin - replaces the write end of the child's stdin, closing the old one
+func (x *Exe) setstdin(w *os.File) {
+	x.lok.Lock()
+	defer x.lok.Unlock()
+
+	if x.inw != nil {
+		x.inw.Close()
+	}
+	x.inw = w
+}
+
+// dup - duplicates f, the copy can be closed without closing f
+func dup(f *os.File) (*os.File, error) {
+	c, e := f.SyscallConn()
+	if e != nil {
+		return nil, e
+	}
+	fd := -1
+	var err error
+	if e = c.Control(func(d uintptr) { fd, err = syscall.Dup(int(d)) }); e != nil {
+		return nil, e
+	}
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
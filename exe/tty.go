@@ -0,0 +1,115 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// job - a child was stopped or continued
+type job struct {
+	pid     int
+	stopped bool
+	signal  syscall.Signal
+}
+
+// waitid si_code values for stopped and continued children
+const (
+	_CLD_STOPPED   = 5
+	_CLD_CONTINUED = 6
+	_P_PID         = 1
+)
+
+// jobstate - returns a stop or continue of pid without waiting, ok is false
+// if its state has not changed
+func jobstate(pid int) (j job, ok bool) {
+	var si siginfo
+	_, _, e := syscall.Syscall6(
+		syscall.SYS_WAITID,
+		_P_PID, uintptr(pid),
+		uintptr(unsafe.Pointer(&si)),
+		syscall.WSTOPPED|syscall.WCONTINUED|syscall.WNOHANG,
+		0, 0)
+	if e != 0 || si.Pid == 0 {
+		return job{}, false
+	}
+	switch si.Code {
+	case _CLD_STOPPED:
+		return job{pid: pid, stopped: true, signal: syscall.Signal(si.Status)}, true
+	case _CLD_CONTINUED:
+		return job{pid: pid, signal: syscall.SIGCONT}, true
+	}
+	return job{}, false
+}
+
+// IsTerminal - returns true if fd is a terminal
+func IsTerminal(fd int) bool {
+	var t syscall.Termios
+	_, _, e := syscall.Syscall(
+		syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	return e == 0
+}
+
+// tcsetpgrp - makes pgrp the foreground process group of the terminal on
+// fd, the caller must ignore SIGTTOU when it is not in the foreground
+func tcsetpgrp(fd, pgrp int) error {
+	id := int32(pgrp)
+	_, _, e := syscall.Syscall(
+		syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&id)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// foreground - gives the terminal on stdin to pgrp
+func foreground(pgrp int) error {
+	if !IsTerminal(0) {
+		return nil
+	}
+	return tcsetpgrp(0, pgrp)
+}
+
+// jobctl - follows the stops and continues of a foreground child, the
+// terminal is taken back while the child is stopped so drinit can still
+// read it, and handed back when the child continues
+func (x *Exe) jobctl(jobs <-chan job) {
+	for j := range jobs {
+		x.lok.Lock()
+		if j.stopped {
+			x.inf.Stopped.Set()
+		} else {
+			x.inf.Stopped.Clear()
+		}
+		x.lok.Unlock()
+
+		if j.stopped {
+			x.log.Warnf("pid %d stopped by %s, send SIGCONT to resume it", j.pid, j.signal.String())
+			if e := foreground(syscall.Getpgrp()); e != nil {
+				x.log.Errorf("failed to take back the terminal, %s", e.Error())
+			}
+			continue
+		}
+		x.log.Infof("pid %d continued", j.pid)
+		if e := foreground(j.pid); e != nil {
+			x.log.Errorf("failed to give pid %d the terminal, %s", j.pid, e.Error())
+		}
+	}
+}
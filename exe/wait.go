@@ -0,0 +1,81 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exe
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// ExitError - a program that was killed by a signal or exited with a
+// non zero status
+type ExitError struct {
+	syscall.WaitStatus
+}
+
+func (e *ExitError) Error() string {
+	if e.Signaled() {
+		s := "signal: " + e.Signal().String()
+		if e.CoreDump() {
+			s += " (core dumped)"
+		}
+		return s
+	}
+	return fmt.Sprintf("exit status %d", e.ExitStatus())
+}
+
+// exit - the status and resource usage of a child that has been waited on
+type exit struct {
+	ws  syscall.WaitStatus
+	ru  syscall.Rusage
+	err error
+}
+
+// wait - waits for pid to exit, collecting its status and resource usage
+func wait(pid int) exit {
+	var x exit
+
+	_, x.err = syscall.Wait4(pid, &x.ws, 0, &x.ru)
+	for x.err == syscall.EINTR {
+		_, x.err = syscall.Wait4(pid, &x.ws, 0, &x.ru)
+	}
+	return x
+}
+
+const (
+	_P_ALL   = 0
+	_WNOWAIT = 0x1000000
+)
+
+// peek - returns the pid of a child that has exited without reaping it,
+// 0 if no child has exited yet
+func peek() (int, error) {
+	var si siginfo
+	_, _, e := syscall.Syscall6(
+		syscall.SYS_WAITID,
+		_P_ALL, 0,
+		uintptr(unsafe.Pointer(&si)),
+		syscall.WEXITED|syscall.WNOHANG|_WNOWAIT,
+		0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(si.Pid), nil
+}
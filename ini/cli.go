@@ -21,10 +21,17 @@ package ini
 import (
 	"fmt"
 	"os"
+	"os/user"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/streamz/drinit/cgroup"
 	"github.com/streamz/drinit/cli"
+	"github.com/streamz/drinit/exe"
 	"github.com/streamz/drinit/log"
+	"github.com/streamz/drinit/sig"
 )
 
 const runmsg = "the script or command to run for a trap task. if the cmd has 0 args, the signal that triggered it will be in $1"
@@ -32,18 +39,129 @@ const verbosemsg = "verbose logging"
 const helpemsg = "displays help usage"
 const trapmsg = "the signals to trap"
 const fdmsg = "the named pipe"
+const idlemsg = "restart the program if it writes nothing to stdout or stderr for this long, 0 disables"
+const gracemsg = "how long to wait for the program to stop before sending SIGKILL, 0 waits forever"
+const dumpdirmsg = "capture diagnostics into this directory before killing a hung program"
+const dumpsigmsg = "the signal sent to the program before capturing diagnostics, ie. SIGQUIT"
+const dumpwaitmsg = "how long to wait after sending the dump signal"
+const historymsg = "the number of program runs kept in the history"
+const historyfilemsg = "persist the run history to this file so it survives a re-exec"
+const subreapermsg = "become a child subreaper, so orphans are reaped when drinit is not PID 1"
+const teardownmsg = "how long processes left running on shutdown are given to exit before they are killed"
+const scripttimeoutmsg = "stop a drinitctl up or down script that runs longer than this, 0 only stops it on shutdown"
+const onmsg = "run a script or command when a signal is trapped, ie. SIGHUP=/reload.sh, the signal is added to the traps, repeat for each signal"
+const trapmodemsg = "what to do with a trapped signal, SIG=mode where mode is trap, run-forward, forward-run or exit-code"
+const traprulemsg = "what happens to a trapped signal delivered while its trap is pending or running, SIG=rule where rule is coalesce, queue, skip or restart"
+const traptimeoutmsg = "kill a trap script that runs longer than this, SIG=duration, ie. SIGHUP=30s"
+const trapdepthmsg = "the number of deliveries queued for a trap with the queue rule"
+const eventsmsg = "the number of received signals kept for drinitctl signals"
+const ttymsg = "interactive mode, the program gets the terminal's foreground process group so ctrl-c and ctrl-z reach it"
+const ptymsg = "run the program on a pseudo-terminal, for programs that buffer or change their output when it is not a tty"
+const stdinmsg = "what the program reads from, inherit, null or pipe, a pipe is written with drinitctl stdin"
+const usermsg = "run the program as this user, by name or id, or user:group, supplementary groups are read from /etc/group"
+const groupmsg = "run the program with this primary group, by name or id"
+const capboundmsg = "the capabilities kept in the program's bounding set, ie. NET_BIND_SERVICE,CHOWN or none, all others are dropped"
+const capambientmsg = "capabilities raised in the program's ambient set, so it keeps them as a non-root user, ie. NET_BIND_SERVICE"
+const nonewprivsmsg = "set no_new_privs, the program cannot gain privileges through setuid binaries or file capabilities"
+const rlimitmsg = "a resource limit for the program, traps and scripts, name=soft[:hard], ie. nofile=1024:4096 or core=unlimited"
+const umaskmsg = "the umask of the program, traps and scripts in octal, ie. 027"
+const nicemsg = "the nice value of the program, traps and scripts, -20 to 19"
+const iopriomsg = "the io scheduling class and level of the program, traps and scripts, rt, be or idle, ie. be:4"
+const cpusmsg = "the cpus the program, traps and scripts may run on, ie. 0-3,6"
+const oommsg = "the oom_score_adj of the program, traps and scripts, -1000 to 1000"
+const cgroupmsg = "run the program, traps and scripts in their own cgroup v2 groups under drinit's delegated cgroup"
+const memorymaxmsg = "the program's cgroup memory.max, ie. 512M, implies --cgroup"
+const cpumaxmsg = "the program's cgroup cpu.max, in cpus, ie. 1.5, or quota:period in microseconds, implies --cgroup"
+const pidsmaxmsg = "the program's cgroup pids.max, implies --cgroup"
+const restartoommsg = "restart the program when the kernel oom killer kills it, a program that exits otherwise stays down"
+const restartdelaymsg = "how long to wait before restarting a program killed by the oom killer"
+const remapmsg = "translate a signal before it is forwarded to the program, ie. SIGTERM:SIGQUIT"
+const configmsg = "read flags from this file, one per line as name value, command line flags are applied after it"
 const usage = "/drinit -- /program -and -args"
 
 // CliContext -
 type CliContext struct {
-	Pipe string
+	Pipe                       string
+	Idle, Grace, Teardown      time.Duration
+	Diags                      DiagOpts
+	History, Events            int
+	HistoryFile                string
+	Subreaper, Tty, Pty        bool
 	Supervise, TrapArgs, Traps []string
+	// Actions - the command run for each trapped signal, signals without
+	// an action run TrapArgs
+	Actions map[os.Signal][]string
+	// Modes - what is done with each trapped signal, signals without a
+	// mode are only trapped
+	Modes map[os.Signal]sig.Mode
+	// Rules - the queue rule of each trapped signal, Depth - the queue
+	// depth for the queue rule
+	Rules map[os.Signal]sig.Rule
+	Depth int
+	// Timeouts - how long each trap may run before it is killed
+	Timeouts map[os.Signal]time.Duration
+	// Remap - signals translated before they are forwarded
+	Remap map[os.Signal]os.Signal
+	// ScriptTimeout - how long a drinitctl up or down script may run
+	ScriptTimeout time.Duration
+	// Stdin - what the program reads from
+	Stdin exe.Stdin
+	// User, Group - who the program runs as, nil runs it as drinit's user
+	User  *user.User
+	Group *user.Group
+	// Caps - the program's capabilities
+	Caps exe.Caps
+	// Limits - the resource limits and scheduling attributes of the program,
+	// traps and scripts
+	Limits exe.Limits
+	// Cgroup - run the program, traps and scripts in cgroup v2 groups,
+	// CgroupLimits - the limits of the program's group
+	Cgroup       bool
+	CgroupLimits cgroup.Limits
+	// RestartOOM - restart the program after an oom kill, RestartDelay -
+	// how long to wait before the restart
+	RestartOOM   bool
+	RestartDelay time.Duration
 }
 
 func (c CliContext) String() string {
-	return fmt.Sprintf(
-		"pipe: %v, program: %v, traps: %v, run: %v",
-		c.Pipe, c.Supervise, c.Traps, c.TrapArgs)
+	fields := []string{
+		fmt.Sprintf("pipe: %v", c.Pipe),
+		fmt.Sprintf("program: %v", c.Supervise),
+		fmt.Sprintf("traps: %v", c.Traps),
+		fmt.Sprintf("run: %v", c.TrapArgs),
+		fmt.Sprintf("actions: %v", c.Actions),
+		fmt.Sprintf("modes: %v", c.Modes),
+		fmt.Sprintf("rules: %v", c.Rules),
+		fmt.Sprintf("timeouts: %v", c.Timeouts),
+		fmt.Sprintf("remap: %v", c.Remap),
+		fmt.Sprintf("idle: %v", c.Idle),
+		fmt.Sprintf("grace: %v", c.Grace),
+		fmt.Sprintf("diags: %+v", c.Diags),
+		fmt.Sprintf("subreaper: %v", c.Subreaper),
+		fmt.Sprintf("teardown: %v", c.Teardown),
+		fmt.Sprintf("script timeout: %v", c.ScriptTimeout),
+		fmt.Sprintf("tty: %v", c.Tty),
+		fmt.Sprintf("pty: %v", c.Pty),
+		fmt.Sprintf("stdin: %v", c.Stdin),
+		fmt.Sprintf("user: %s", c.identity()),
+		fmt.Sprintf("caps: %+v", c.Caps),
+		fmt.Sprintf("limits: %s", c.Limits),
+		fmt.Sprintf("cgroup: %v %s", c.Cgroup, c.CgroupLimits),
+		fmt.Sprintf("restart oom: %v", c.RestartOOM),
+	}
+	return strings.Join(fields, ", ")
+}
+
+// identity - user:group the program runs as
+func (c CliContext) identity() string {
+	if c.User == nil {
+		return "drinit"
+	}
+	if c.Group == nil {
+		return c.User.Username
+	}
+	return c.User.Username + ":" + c.Group.Name
 }
 
 // NewCli -
@@ -54,15 +172,68 @@ func NewCli() *CliContext {
 	traprun := cmd.String("run", "r", "", runmsg)
 	traps := cmd.StringSlice("traps", "t", trapmsg)
 	verbose := cmd.Bool("verbose", "v", false, verbosemsg)
+	idle := cmd.Duration("idle", "i", 0, idlemsg)
+	grace := cmd.Duration("grace", "", 0, gracemsg)
+	dumpdir := cmd.String("dump-dir", "", "", dumpdirmsg)
+	dumpsig := cmd.String("dump-signal", "", "", dumpsigmsg)
+	dumpwait := cmd.Duration("dump-wait", "", 2*time.Second, dumpwaitmsg)
+	history := cmd.Int("history", "", DefaultHistory, historymsg)
+	historyfile := cmd.String("history-file", "", "", historyfilemsg)
+	subreaper := cmd.Bool("subreaper", "s", false, subreapermsg)
+	teardown := cmd.Duration("teardown", "", DefaultTeardown, teardownmsg)
+	scripttimeout := cmd.Duration("script-timeout", "", 0, scripttimeoutmsg)
+	on := cmd.Strings("on", "", onmsg)
+	trapmodes := cmd.StringSlice("trap-mode", "", trapmodemsg)
+	traprules := cmd.StringSlice("trap-rule", "", traprulemsg)
+	trapdepth := cmd.Int("trap-depth", "", sig.DefaultDepth, trapdepthmsg)
+	traptimeouts := cmd.StringSlice("trap-timeout", "", traptimeoutmsg)
+	remaps := cmd.StringSlice("remap", "", remapmsg)
+	tty := cmd.Bool("tty", "", false, ttymsg)
+	pty := cmd.Bool("pty", "", false, ptymsg)
+	stdin := cmd.String("stdin", "", exe.StdinInherit.String(), stdinmsg)
+	usr := cmd.String("user", "u", "", usermsg)
+	grp := cmd.String("group", "g", "", groupmsg)
+	capbound := cmd.StringSlice("cap-bound", "", capboundmsg)
+	capambient := cmd.StringSlice("cap-ambient", "", capambientmsg)
+	nonewprivs := cmd.Bool("no-new-privs", "", false, nonewprivsmsg)
+	rlimits := cmd.StringSlice("rlimit", "", rlimitmsg)
+	umask := cmd.String("umask", "", "", umaskmsg)
+	nice := cmd.String("nice", "", "", nicemsg)
+	ioprio := cmd.String("ioprio", "", "", iopriomsg)
+	cpus := cmd.String("cpus", "", "", cpusmsg)
+	oom := cmd.String("oom-score-adj", "", "", oommsg)
+	cg := cmd.Bool("cgroup", "", false, cgroupmsg)
+	memorymax := cmd.String("memory-max", "", "", memorymaxmsg)
+	cpumax := cmd.String("cpu-max", "", "", cpumaxmsg)
+	pidsmax := cmd.String("pids-max", "", "", pidsmaxmsg)
+	rstoom := cmd.Bool("restart-oom", "", false, restartoommsg)
+	rstdelay := cmd.Duration("restart-delay", "", DefaultRestartDelay, restartdelaymsg)
+	events := cmd.Int("signal-events", "", sig.DefaultEvents, eventsmsg)
+	// read by Peek before parsing, registered so the flag parses
+	cmd.String("config", "c", "", configmsg)
 
 	logger := log.Logger()
-	e := cmd.Parse()
-	if e != nil {
-		logger.Errorf("%s, %s", e.Error(), strings.Join(os.Args, " "))
+	// fail - logs a bad argument and exits with the usage
+	fail := func(e error) {
+		logger.Error(e.Error())
 		cmd.Usage(usage)
 		os.Exit(1)
 	}
 
+	args := os.Args[1:]
+	if path, ok := cmd.Peek(args, "config", "c"); ok && len(path) > 0 {
+		fargs, e := cli.ReadArgs(path)
+		if e != nil {
+			logger.Errorf("config %s, %s", path, e.Error())
+			os.Exit(1)
+		}
+		args = append(fargs, args...)
+	}
+	e := cmd.ParseSlice(args)
+	if e != nil {
+		fail(fmt.Errorf("%s, %s", e.Error(), strings.Join(os.Args, " ")))
+	}
+
 	if *help {
 		cmd.Usage(usage)
 		os.Exit(0)
@@ -74,15 +245,331 @@ func NewCli() *CliContext {
 
 	program := cmd.Args()
 	if len(program) == 0 {
-		logger.Error("program not defined")
-		cmd.Usage(usage)
-		os.Exit(1)
+		fail(fmt.Errorf("program not defined"))
+	}
+
+	diags := DiagOpts{Dir: *dumpdir, Wait: *dumpwait}
+	if len(*dumpsig) > 0 {
+		s, e := sig.ToSignal(*dumpsig)
+		if e != nil {
+			fail(e)
+		}
+		diags.Signal = s
+	}
+
+	actions, traplist, e := parseactions(*on, *traps)
+	if e != nil {
+		fail(e)
+	}
+	modes, traplist, e := parsemodes(*trapmodes, traplist)
+	if e != nil {
+		fail(e)
+	}
+	rules, traplist, e := parserules(*traprules, traplist)
+	if e != nil {
+		fail(e)
+	}
+	timeouts, traplist, e := parsetimeouts(*traptimeouts, traplist)
+	if e != nil {
+		fail(e)
+	}
+	remap, e := parseremap(*remaps)
+	if e != nil {
+		fail(e)
+	}
+	in, e := exe.ToStdin(*stdin)
+	if e != nil {
+		fail(e)
+	}
+	osusr, osgrp, e := parseidentity(*usr, *grp)
+	if e != nil {
+		fail(e)
+	}
+	caps, e := parsecaps(*capbound, *capambient, *nonewprivs)
+	if e != nil {
+		fail(e)
+	}
+	limits, e := parselimits(*rlimits, *umask, *nice, *ioprio, *cpus, *oom)
+	if e != nil {
+		fail(e)
+	}
+	cglimits, e := parsecgroup(*memorymax, *cpumax, *pidsmax)
+	if e != nil {
+		fail(e)
 	}
 
 	return &CliContext{
-		Pipe: *pipe,
-		Supervise: program,
-		TrapArgs: strings.Split(strings.Trim(*traprun, " "), " "),
-		Traps: *traps,
+		Pipe:          *pipe,
+		Idle:          *idle,
+		Grace:         *grace,
+		Diags:         diags,
+		History:       *history,
+		Events:        *events,
+		HistoryFile:   *historyfile,
+		Subreaper:     *subreaper,
+		Teardown:      *teardown,
+		ScriptTimeout: *scripttimeout,
+		Tty:           *tty,
+		Pty:           *pty,
+		Stdin:         in,
+		User:          osusr,
+		Group:         osgrp,
+		Caps:          caps,
+		Limits:        limits,
+		Cgroup:        *cg || cglimits != (cgroup.Limits{}),
+		CgroupLimits:  cglimits,
+		RestartOOM:    *rstoom,
+		RestartDelay:  *rstdelay,
+		Supervise:     program,
+		TrapArgs:      strings.Fields(*traprun),
+		Traps:         traplist,
+		Actions:       actions,
+		Modes:         modes,
+		Rules:         rules,
+		Depth:         *trapdepth,
+		Timeouts:      timeouts,
+		Remap:         remap,
+	}
+}
+
+// parseactions - parses SIG=cmd actions, every signal with an action is
+// appended to the traps if it is not already trapped
+func parseactions(on, traps []string) (map[os.Signal][]string, []string, error) {
+	actions := make(map[os.Signal][]string, len(on))
+	for _, a := range on {
+		s, name, cmd, e := sigpair(a, "SIG=cmd")
+		if e != nil {
+			return nil, nil, e
+		}
+		actions[s] = strings.Fields(cmd)
+		traps = addtrap(traps, s, name)
+	}
+	return actions, traps, nil
+}
+
+// parsemodes - parses SIG=mode trap modes, every signal with a mode is
+// appended to the traps if it is not already trapped
+func parsemodes(modes, traps []string) (map[os.Signal]sig.Mode, []string, error) {
+	res := make(map[os.Signal]sig.Mode, len(modes))
+	for _, m := range modes {
+		s, name, mode, e := sigpair(m, "SIG=mode")
+		if e != nil {
+			return nil, nil, e
+		}
+		if res[s], e = sig.ToMode(strings.TrimSpace(mode)); e != nil {
+			return nil, nil, e
+		}
+		traps = addtrap(traps, s, name)
+	}
+	return res, traps, nil
+}
+
+// parserules - parses SIG=rule queue rules, every signal with a rule is
+// appended to the traps if it is not already trapped
+func parserules(rules, traps []string) (map[os.Signal]sig.Rule, []string, error) {
+	res := make(map[os.Signal]sig.Rule, len(rules))
+	for _, r := range rules {
+		s, name, rule, e := sigpair(r, "SIG=rule")
+		if e != nil {
+			return nil, nil, e
+		}
+		if res[s], e = sig.ToRule(strings.TrimSpace(rule)); e != nil {
+			return nil, nil, e
+		}
+		traps = addtrap(traps, s, name)
+	}
+	return res, traps, nil
+}
+
+// parsetimeouts - parses SIG=duration trap timeouts, every signal with a
+// timeout is appended to the traps if it is not already trapped
+func parsetimeouts(timeouts, traps []string) (map[os.Signal]time.Duration, []string, error) {
+	res := make(map[os.Signal]time.Duration, len(timeouts))
+	for _, t := range timeouts {
+		s, name, d, e := sigpair(t, "SIG=duration")
+		if e != nil {
+			return nil, nil, e
+		}
+		if res[s], e = time.ParseDuration(strings.TrimSpace(d)); e != nil {
+			return nil, nil, e
+		}
+		traps = addtrap(traps, s, name)
+	}
+	return res, traps, nil
+}
+
+// parseremap - parses FROM:TO signal translations
+func parseremap(remaps []string) (map[os.Signal]os.Signal, error) {
+	res := make(map[os.Signal]os.Signal, len(remaps))
+	for _, r := range remaps {
+		kv := strings.SplitN(r, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid remap: %s, expected SIG:SIG", r)
+		}
+		from, e := sig.ToSignal(strings.TrimSpace(kv[0]))
+		if e != nil {
+			return nil, e
+		}
+		to, e := sig.ToSignal(strings.TrimSpace(kv[1]))
+		if e != nil {
+			return nil, e
+		}
+		res[from] = to
+	}
+	return res, nil
+}
+
+// sigpair - splits a SIG=value flag
+func sigpair(a, expect string) (os.Signal, string, string, error) {
+	kv := strings.SplitN(a, "=", 2)
+	if len(kv) != 2 || len(strings.TrimSpace(kv[1])) == 0 {
+		return nil, "", "", fmt.Errorf("invalid value: %s, expected %s", a, expect)
+	}
+	name := strings.TrimSpace(kv[0])
+	s, e := sig.ToSignal(name)
+	if e != nil {
+		return nil, "", "", e
+	}
+	return s, name, kv[1], nil
+}
+
+// addtrap - appends name to the traps unless s is already trapped
+func addtrap(traps []string, s os.Signal, name string) []string {
+	for _, t := range traps {
+		if ts, e := sig.ToSignal(t); e == nil && ts == s {
+			return traps
+		}
+	}
+	return append(traps, name)
+}
+
+// parseidentity - looks up the user the program runs as, usr may be
+// user:group, a group given separately replaces it
+func parseidentity(usr, grp string) (*user.User, *user.Group, error) {
+	var u *user.User
+	var g *user.Group
+	var e error
+	if len(usr) > 0 {
+		if u, g, e = exe.LookupUserGroup(usr); e != nil {
+			return nil, nil, e
+		}
+	}
+	if len(grp) > 0 {
+		if g, e = exe.LookupGroup(grp); e != nil {
+			return nil, nil, e
+		}
+		if u == nil {
+			// the group applies to drinit's user
+			if u, e = user.Current(); e != nil {
+				return nil, nil, e
+			}
+		}
+	}
+	return u, g, nil
+}
+
+// parsecaps - parses the bounding and ambient sets, every ambient
+// capability must be in the bounding set
+func parsecaps(bound, ambient []string, nonewprivs bool) (exe.Caps, error) {
+	caps := exe.Caps{NoNewPrivs: nonewprivs}
+	var e error
+	if len(bound) > 0 {
+		if caps.Bounding, e = exe.ToCaps(bound); e != nil {
+			return caps, e
+		}
+	}
+	if len(ambient) > 0 {
+		if caps.Ambient, e = exe.ToCaps(ambient); e != nil {
+			return caps, e
+		}
+	}
+	if caps.Bounding == nil {
+		return caps, nil
+	}
+	for _, a := range caps.Ambient {
+		found := false
+		for _, b := range caps.Bounding {
+			found = found || a == b
+		}
+		if !found {
+			return caps, fmt.Errorf("ambient capability %s is not in the bounding set", exe.CapToName(a))
+		}
+	}
+	return caps, nil
+}
+
+// parselimits - parses the program's resource limits and scheduling
+// attributes, empty values are not set
+func parselimits(rlimits []string, umask, nice, ioprio, cpus, oom string) (exe.Limits, error) {
+	var l exe.Limits
+	for _, r := range rlimits {
+		res, rl, e := exe.ToRlimit(r)
+		if e != nil {
+			return l, e
+		}
+		if l.Rlimits == nil {
+			l.Rlimits = make(map[int]syscall.Rlimit, len(rlimits))
+		}
+		l.Rlimits[res] = rl
+	}
+	if len(umask) > 0 {
+		m, e := strconv.ParseUint(umask, 8, 32)
+		if e != nil || m > 0777 {
+			return l, fmt.Errorf("invalid umask: %s", umask)
+		}
+		u := int(m)
+		l.Umask = &u
+	}
+	if len(nice) > 0 {
+		n, e := strconv.Atoi(nice)
+		if e != nil || n < -20 || n > 19 {
+			return l, fmt.Errorf("invalid nice value: %s", nice)
+		}
+		l.Nice = &n
+	}
+	if len(ioprio) > 0 {
+		p, e := exe.ToIoprio(ioprio)
+		if e != nil {
+			return l, e
+		}
+		l.Ioprio = &p
+	}
+	if len(cpus) > 0 {
+		c, e := exe.ToCpus(cpus)
+		if e != nil {
+			return l, e
+		}
+		l.Affinity = c
+	}
+	if len(oom) > 0 {
+		o, e := strconv.Atoi(oom)
+		if e != nil || o < -1000 || o > 1000 {
+			return l, fmt.Errorf("invalid oom_score_adj: %s", oom)
+		}
+		l.OOMScoreAdj = &o
+	}
+	return l, nil
+}
+
+// parsecgroup - parses the limits of the program's cgroup, empty values
+// are not set
+func parsecgroup(memory, cpu, pids string) (cgroup.Limits, error) {
+	var l cgroup.Limits
+	var e error
+	if len(memory) > 0 {
+		if l.Memory, e = cgroup.ToMemory(memory); e != nil {
+			return l, e
+		}
+	}
+	if len(cpu) > 0 {
+		if l.CPU, e = cgroup.ToCPU(cpu); e != nil {
+			return l, e
+		}
+	}
+	if len(pids) > 0 {
+		if l.Pids, e = cgroup.ToPids(pids); e != nil {
+			return l, e
+		}
 	}
+	return l, nil
 }
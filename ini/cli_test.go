@@ -0,0 +1,188 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/streamz/drinit/cgroup"
+	"github.com/streamz/drinit/sig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseActions(t *testing.T) {
+	actions, traps, err := parseactions(
+		[]string{"SIGTERM=/drain.sh", "SIGHUP=/reload.sh -f /etc/app.conf"},
+		[]string{"SIGTERM", "SIGUSR1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SIGTERM", "SIGUSR1", "SIGHUP"}, traps)
+	assert.Equal(t, []string{"/drain.sh"}, actions[syscall.SIGTERM])
+	assert.Equal(t, []string{"/reload.sh", "-f", "/etc/app.conf"}, actions[syscall.SIGHUP])
+	_, ok := actions[syscall.SIGUSR1]
+	assert.False(t, ok, "SIGUSR1 should fall back to -r")
+
+	// --on is not split on commas so a command keeps them
+	actions, _, err = parseactions([]string{"SIGHUP=/bin/echo a,b"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/bin/echo", "a,b"}, actions[syscall.SIGHUP])
+
+	_, _, err = parseactions([]string{"SIGHUP"}, nil)
+	assert.Error(t, err)
+	_, _, err = parseactions([]string{"SIGNOPE=/x.sh"}, nil)
+	assert.Error(t, err)
+}
+
+func TestParseModes(t *testing.T) {
+	modes, traps, err := parsemodes(
+		[]string{"SIGTERM=run-forward", "SIGHUP=exit-code"},
+		[]string{"SIGTERM"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SIGTERM", "SIGHUP"}, traps)
+	assert.Equal(t, sig.ModeRunForward, modes[syscall.SIGTERM])
+	assert.Equal(t, sig.ModeExitCode, modes[syscall.SIGHUP])
+
+	_, _, err = parsemodes([]string{"SIGHUP=later"}, nil)
+	assert.Error(t, err)
+}
+
+func TestParseRemap(t *testing.T) {
+	remap, err := parseremap([]string{"SIGTERM:SIGQUIT", "SIGINT:SIGTERM"})
+	assert.NoError(t, err)
+	assert.Equal(t, syscall.SIGQUIT, remap[syscall.SIGTERM])
+	assert.Equal(t, syscall.SIGTERM, remap[syscall.SIGINT])
+
+	_, err = parseremap([]string{"SIGTERM"})
+	assert.Error(t, err)
+	_, err = parseremap([]string{"SIGTERM:SIGNOPE"})
+	assert.Error(t, err)
+}
+
+func TestParseRules(t *testing.T) {
+	rules, traps, err := parserules([]string{"SIGHUP=queue"}, []string{"SIGTERM"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SIGTERM", "SIGHUP"}, traps)
+	assert.Equal(t, sig.RuleQueue, rules[syscall.SIGHUP])
+	assert.Equal(t, sig.RuleCoalesce, rules[syscall.SIGTERM])
+
+	_, _, err = parserules([]string{"SIGHUP=stack"}, nil)
+	assert.Error(t, err)
+}
+
+func TestParseTimeouts(t *testing.T) {
+	timeouts, traps, err := parsetimeouts([]string{"SIGHUP=30s"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SIGHUP"}, traps)
+	assert.Equal(t, 30*time.Second, timeouts[syscall.SIGHUP])
+
+	_, _, err = parsetimeouts([]string{"SIGHUP=soon"}, nil)
+	assert.Error(t, err)
+}
+
+func TestParseIdentity(t *testing.T) {
+	u, g, err := parseidentity("root:0", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "0", u.Uid)
+	assert.Equal(t, "0", g.Gid)
+
+	u, g, err = parseidentity("0", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, "root", u.Username)
+	assert.Equal(t, "0", g.Gid, "--group replaces the user's group")
+
+	u, g, err = parseidentity("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, u, "the program runs as drinit's user")
+	assert.Nil(t, g)
+
+	_, _, err = parseidentity("no-such-user", "")
+	assert.Error(t, err)
+}
+
+func TestParseCaps(t *testing.T) {
+	caps, err := parsecaps([]string{"NET_BIND_SERVICE,CHOWN"}, []string{"net_bind_service"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []uintptr{10, 0}, caps.Bounding)
+	assert.Equal(t, []uintptr{10}, caps.Ambient)
+	assert.True(t, caps.NoNewPrivs)
+
+	caps, err = parsecaps(nil, []string{"NET_RAW"}, false)
+	assert.NoError(t, err)
+	assert.Nil(t, caps.Bounding, "the bounding set is kept")
+
+	_, err = parsecaps([]string{"none"}, []string{"NET_RAW"}, false)
+	assert.Error(t, err, "ambient capabilities must be in the bounding set")
+}
+
+func TestParseCgroup(t *testing.T) {
+	l, err := parsecgroup("1G", "0.5", "100")
+	assert.NoError(t, err)
+	assert.Equal(t, cgroup.Limits{Memory: "1073741824", CPU: "50000 100000", Pids: "100"}, l)
+
+	l, err = parsecgroup("", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, cgroup.Limits{}, l)
+
+	for _, bad := range [][]string{{"a lot", "", ""}, {"", "-1", ""}, {"", "", "0"}} {
+		_, err = parsecgroup(bad[0], bad[1], bad[2])
+		assert.Error(t, err, "%v", bad)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	l, err := parselimits([]string{"nofile=1024:4096", "core=unlimited"}, "027", "-5", "be:4", "0-1", "-500")
+	assert.NoError(t, err)
+	assert.Equal(t, syscall.Rlimit{Cur: 1024, Max: 4096}, l.Rlimits[syscall.RLIMIT_NOFILE])
+	assert.Equal(t, 027, *l.Umask)
+	assert.Equal(t, -5, *l.Nice)
+	assert.Equal(t, "be:4", l.Ioprio.String())
+	assert.Equal(t, []int{0, 1}, l.Affinity)
+	assert.Equal(t, -500, *l.OOMScoreAdj)
+	assert.Equal(t, "[core=unlimited:unlimited nofile=1024:4096 umask=0027 nice=-5 ioprio=be:4 cpus=[0 1] oom_score_adj=-500]", l.String())
+
+	l, err = parselimits(nil, "", "", "", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, l.Nice, "unset values keep drinit's")
+
+	for _, bad := range [][]string{{"files=10", "", "", "", "", ""}, {"", "999", "", "", "", ""}, {"", "", "20", "", "", ""}, {"", "", "", "best:1", "", ""}, {"", "", "", "", "3-1", ""}, {"", "", "", "", "", "2000"}} {
+		var rl []string
+		if len(bad[0]) > 0 {
+			rl = []string{bad[0]}
+		}
+		_, err = parselimits(rl, bad[1], bad[2], bad[3], bad[4], bad[5])
+		assert.Error(t, err, "%v", bad)
+	}
+}
+
+func TestCliString(t *testing.T) {
+	c := CliContext{
+		Pipe:       "/tmp/drinit.pipe",
+		Supervise:  []string{"sleep", "1"},
+		Grace:      time.Second,
+		RestartOOM: true,
+	}
+	s := c.String()
+	assert.Contains(t, s, "pipe: /tmp/drinit.pipe, program: [sleep 1], ")
+	assert.Contains(t, s, ", grace: 1s, ")
+	assert.Contains(t, s, ", user: drinit, ")
+	assert.Contains(t, s, ", restart oom: true")
+}
@@ -0,0 +1,130 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/streamz/drinit/proc"
+)
+
+// DiagOpts - diagnostics captured before a hung program is killed
+type DiagOpts struct {
+	// Dir - where captures are written, diagnostics are disabled when empty
+	Dir string
+	// Signal - sent to the process group before capturing, ie. SIGQUIT
+	// for a JVM thread dump, nil sends nothing
+	Signal os.Signal
+	// Wait - how long to wait after sending Signal
+	Wait time.Duration
+}
+
+// files copied from /proc/<pid> for every process in the tree
+var diagfiles = []string{"status", "stack", "wchan"}
+
+// diagnose - signals the program's process group and saves the /proc
+// state of its process tree, returns the capture directory
+func (i *Init) diagnose(pid int) string {
+	d := i.dia
+	if d.Dir == "" || pid <= 0 {
+		return ""
+	}
+
+	if s, ok := d.Signal.(syscall.Signal); ok {
+		if e := syscall.Kill(-pid, s); e != nil {
+			i.log.Errorf("diagnostics: signal %v to pid %d, %s", s, pid, e.Error())
+		} else {
+			time.Sleep(d.Wait)
+		}
+	}
+
+	dir := filepath.Join(d.Dir, fmt.Sprintf("%s-%d", time.Now().Format("20060102T150405"), pid))
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		i.log.Errorf("diagnostics: %s", e.Error())
+		return ""
+	}
+
+	tree, e := proc.Tree(pid)
+	if e != nil {
+		i.log.Errorf("diagnostics: %s", e.Error())
+		return ""
+	}
+
+	for _, st := range tree {
+		if e := capture(dir, st.Pid); e != nil {
+			i.log.Errorf("diagnostics: pid %d, %s", st.Pid, e.Error())
+		}
+	}
+
+	i.log.Infof("diagnostics for pid %d saved to %s", pid, dir)
+	return dir
+}
+
+func capture(dir string, pid int) error {
+	pdir := filepath.Join(dir, strconv.Itoa(pid))
+	if e := os.MkdirAll(pdir, 0700); e != nil {
+		return e
+	}
+
+	ioutil.WriteFile(filepath.Join(pdir, "cmdline"), []byte(proc.Cmdline(pid)+"\n"), 0600)
+
+	for _, f := range diagfiles {
+		// stack needs CAP_SYS_ADMIN, record why a file is missing
+		b, e := ioutil.ReadFile(proc.Path(pid, f))
+		if e != nil {
+			b = []byte(e.Error() + "\n")
+		}
+		if e = ioutil.WriteFile(filepath.Join(pdir, f), b, 0600); e != nil {
+			return e
+		}
+	}
+
+	return ioutil.WriteFile(filepath.Join(pdir, "fd"), []byte(fds(pid)), 0600)
+}
+
+func fds(pid int) string {
+	fdir := proc.Path(pid, "fd")
+	d, e := os.Open(fdir)
+	if e != nil {
+		return e.Error() + "\n"
+	}
+	defer d.Close()
+
+	names, e := d.Readdirnames(-1)
+	if e != nil {
+		return e.Error() + "\n"
+	}
+
+	var sb strings.Builder
+	for _, n := range names {
+		l, e := os.Readlink(filepath.Join(fdir, n))
+		if e != nil {
+			l = e.Error()
+		}
+		fmt.Fprintf(&sb, "%s -> %s\n", n, l)
+	}
+	return sb.String()
+}
@@ -0,0 +1,279 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/streamz/drinit/exe"
+	"github.com/streamz/drinit/log"
+	"github.com/streamz/drinit/sig"
+)
+
+// triggers - what ended a run of the program
+const (
+	// TriggerIPC - stopped or cycled by drinitctl
+	TriggerIPC = "ipc"
+	// TriggerProbe - restarted by a liveness check
+	TriggerProbe = "probe"
+	// TriggerTrap - stopped or cycled by the exit code of a trap
+	TriggerTrap = "trap"
+	// TriggerShutdown - stopped because drinit is shutting down
+	TriggerShutdown = "shutdown"
+	// TriggerCrash - exited on its own with a failure
+	TriggerCrash = "crash"
+	// TriggerExit - exited on its own with status 0
+	TriggerExit = "exit"
+	// TriggerOOM - killed by the kernel's oom killer
+	TriggerOOM = "oom"
+)
+
+// DefaultHistory - the default number of runs kept
+const DefaultHistory = 32
+
+// Run - one generation of the supervised program
+type Run struct {
+	Pid     int           `json:"pid"`
+	StartT  int64         `json:"start"`
+	EndT    int64         `json:"end"`
+	Exit    int           `json:"exit"`
+	Status  int           `json:"status"`
+	Signal  string        `json:"signal,omitempty"`
+	Core    bool          `json:"core,omitempty"`
+	Trigger string        `json:"trigger"`
+	Utime   time.Duration `json:"utime"`
+	Stime   time.Duration `json:"stime"`
+	// MaxRSS - in kilobytes
+	MaxRSS int64 `json:"maxrss"`
+	// CgroupCPU, MemoryPeak - cpu time and peak memory in bytes of the
+	// run's cgroup, descendants of the program included, 0 without cgroups
+	CgroupCPU  time.Duration `json:"cgroup_cpu,omitempty"`
+	MemoryPeak int64         `json:"memory_peak,omitempty"`
+}
+
+// history - a bounded, optionally persisted, list of runs
+type history struct {
+	log  *log.Log
+	lok  sync.Mutex
+	max  int
+	file string
+	runs []Run
+	why  map[*exe.Exe]string
+	// rec - closed once the run of a started program is recorded
+	rec map[*exe.Exe]chan struct{}
+}
+
+func newhistory(max int, file string) *history {
+	if max <= 0 {
+		max = DefaultHistory
+	}
+	h := &history{
+		log:  log.Logger(),
+		max:  max,
+		file: file,
+		why:  make(map[*exe.Exe]string),
+		rec:  make(map[*exe.Exe]chan struct{}),
+	}
+	if len(file) > 0 {
+		if e := h.load(); e != nil && !os.IsNotExist(e) {
+			h.log.Errorf("history: %s", e.Error())
+		}
+	}
+	return h
+}
+
+// cause - records what is about to stop a run, the first cause wins, it
+// is ignored when exc has no run pending so done always consumes it
+func (h *history) cause(exc *exe.Exe, trigger string) {
+	h.lok.Lock()
+	defer h.lok.Unlock()
+	if _, live := h.rec[exc]; !live {
+		return
+	}
+	if _, ok := h.why[exc]; !ok {
+		h.why[exc] = trigger
+	}
+}
+
+// started - a run of exc is pending until done is called for it
+func (h *history) started(exc *exe.Exe) {
+	h.lok.Lock()
+	defer h.lok.Unlock()
+	h.rec[exc] = make(chan struct{})
+}
+
+// recorded - closed once the run of exc is in the history, or right away
+// if there is no pending run
+func (h *history) recorded(exc *exe.Exe) <-chan struct{} {
+	h.lok.Lock()
+	defer h.lok.Unlock()
+	if c, ok := h.rec[exc]; ok {
+		return c
+	}
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+// done - adds a finished run to the history
+func (h *history) done(exc *exe.Exe, inf exe.Info) Run {
+	h.lok.Lock()
+	defer h.lok.Unlock()
+
+	trigger, ok := h.why[exc]
+	delete(h.why, exc)
+	if !ok {
+		trigger = TriggerExit
+		if inf.Exit != 0 || inf.Error != nil {
+			trigger = TriggerCrash
+		}
+	}
+
+	r := Run{
+		Pid:     inf.Pid,
+		StartT:  inf.StartT,
+		EndT:    inf.EndT,
+		Exit:    inf.Exit,
+		Status:  inf.Status,
+		Signal:  signame(inf.Signal),
+		Core:    inf.Core,
+		Trigger: trigger,
+		Utime:   inf.Utime,
+		Stime:   inf.Stime,
+		MaxRSS:  inf.MaxRSS,
+	}
+	if inf.Usage != nil {
+		r.CgroupCPU = inf.Usage.CPU
+		r.MemoryPeak = inf.Usage.MemoryPeak
+	}
+
+	h.runs = append(h.runs, r)
+	if over := len(h.runs) - h.max; over > 0 {
+		h.runs = append([]Run(nil), h.runs[over:]...)
+	}
+
+	if len(h.file) > 0 {
+		if e := h.save(); e != nil {
+			h.log.Errorf("history: %s", e.Error())
+		}
+	}
+	if c, ok := h.rec[exc]; ok {
+		close(c)
+		delete(h.rec, exc)
+	}
+	return r
+}
+
+func (h *history) list() []Run {
+	h.lok.Lock()
+	defer h.lok.Unlock()
+	return append([]Run(nil), h.runs...)
+}
+
+func (h *history) String() string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tSTART\tEND\tRUNTIME\tSTATUS\tSIGNAL\tCORE\tTRIGGER\tUTIME\tSTIME\tMAXRSS\tCGCPU\tMEMPEAK")
+	for _, r := range h.list() {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%d\t%s\t%v\t%s\t%v\t%v\t%dK\t%s\t%s\n",
+			r.Pid, stamp(r.StartT), stamp(r.EndT),
+			time.Duration(r.EndT-r.StartT).Round(time.Millisecond),
+			r.Status, dash(r.Signal), r.Core, r.Trigger, r.Utime, r.Stime, r.MaxRSS,
+			cgcpu(r.CgroupCPU), kbytes(r.MemoryPeak))
+	}
+	w.Flush()
+	return sb.String()
+}
+
+func (h *history) load() error {
+	b, e := ioutil.ReadFile(h.file)
+	if e != nil {
+		return e
+	}
+	var runs []Run
+	if e = json.Unmarshal(b, &runs); e != nil {
+		return e
+	}
+	if over := len(runs) - h.max; over > 0 {
+		runs = runs[over:]
+	}
+	h.runs = runs
+	return nil
+}
+
+// save - writes the history so that it survives a drinit re-exec
+func (h *history) save() error {
+	b, e := json.Marshal(h.runs)
+	if e != nil {
+		return e
+	}
+	tmp := h.file + ".tmp"
+	if e = ioutil.WriteFile(tmp, b, 0600); e != nil {
+		return e
+	}
+	return os.Rename(tmp, h.file)
+}
+
+func signame(s syscall.Signal) string {
+	if s == 0 {
+		return ""
+	}
+	if n := sig.SignalToName(s); len(n) > 0 {
+		return n
+	}
+	return s.String()
+}
+
+// cgcpu - cgroup cpu time, - without cgroups
+func cgcpu(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.String()
+}
+
+// kbytes - bytes in kilobytes, - when unknown
+func kbytes(b int64) string {
+	if b == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dK", b/1024)
+}
+
+func stamp(t int64) string {
+	if t == 0 {
+		return "-"
+	}
+	return time.Unix(0, t).Format("2006-01-02T15:04:05")
+}
+
+func dash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
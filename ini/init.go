@@ -25,26 +25,93 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/streamz/drinit/cgroup"
 	"github.com/streamz/drinit/exe"
 	"github.com/streamz/drinit/ipc"
 	"github.com/streamz/drinit/log"
 	"github.com/streamz/drinit/sig"
+	"github.com/streamz/drinit/util"
 )
 
 // InitOpts -
 type InitOpts struct {
 	Traps []string
 	Signf sig.Signalf
+	// Trapc - a trap handler that stops when its context is done, used
+	// instead of Signf when set
+	Trapc sig.Signalc
+	// Timeouts - how long each trap may run before it is killed
+	Timeouts map[os.Signal]time.Duration
+	// Modes - what is done with each trapped signal, in sig.ModeExitCode
+	// the exit code of the trap decides, see OutcomeSwallow
+	Modes map[os.Signal]sig.Mode
+	// Rules - what happens to a trapped signal delivered while its trap
+	// is pending, Depth - the queue depth for sig.RuleQueue
+	Rules map[os.Signal]sig.Rule
+	Depth int
+	// Remap - signals translated before they are forwarded to the
+	// program, a remapped SIGTERM is also used to stop the program
+	Remap map[os.Signal]os.Signal
 	Delay time.Duration
+	// Idle - restart the program when it writes nothing to stdout or
+	// stderr for this long, 0 disables the check
+	Idle time.Duration
+	// Grace - how long stop waits for the program to exit before
+	// sending SIGKILL, 0 waits forever
+	Grace time.Duration
+	Diags DiagOpts
+	// History - the number of runs kept, HistoryFile persists them
+	History     int
+	HistoryFile string
+	// Subreaper - adopt orphaned descendants when drinit is not PID 1
+	Subreaper bool
+	// Teardown - how long processes left running on shutdown are given
+	// to exit before they are killed, 0 uses DefaultTeardown
+	Teardown time.Duration
+	// ScriptTimeout - how long a drinitctl up or down script may run before
+	// it is stopped, 0 bounds it only by the shutdown of drinit
+	ScriptTimeout time.Duration
+	// Events - the number of signal events kept, 0 uses sig.DefaultEvents
+	Events int
+	// Tty - run the program in the terminal's foreground process group,
+	// for interactive containers
+	Tty bool
+	// Pty - run the program on a pseudo-terminal allocated by drinit, for
+	// programs that buffer or change their output when it is not a tty
+	Pty bool
+	// Stdin - what the program reads from, with exe.StdinPipe it is
+	// written with drinitctl stdin
+	Stdin exe.Stdin
+	// Osusr, Osgrp - who the program runs as, Caps - its capabilities
 	Osusr *user.User
+	Osgrp *user.Group
+	Caps  exe.Caps
+	// Limits - the resource limits and scheduling attributes of the
+	// program and of drinitctl scripts
+	Limits exe.Limits
+	// Cgroups - a delegated cgroup v2 tree, each run of the program and of
+	// a script gets its own group, CgroupLimits - the program's group limits
+	Cgroups      *cgroup.Tree
+	CgroupLimits cgroup.Limits
+	// RestartOOM - restart the program after the kernel's oom killer kills
+	// it, RestartDelay - 0 uses DefaultRestartDelay
+	RestartOOM   bool
+	RestartDelay time.Duration
 }
 
 // Init - The supervisor proces handle
 type Init struct {
+	// 64 bit atomics must be first to stay aligned on 32 bit platforms
+	rsc util.AtomicInt
+	oom util.AtomicInt
+	// the current program's pid, read without i.lok when a signal is
+	// recorded
+	cur util.AtomicInt32
 	log *log.Log
 	ctx context.Context
 	can context.CancelFunc
@@ -55,10 +122,29 @@ type Init struct {
 	exc *exe.Exe
 	syn sync.Once
 	dly time.Duration
+	idl time.Duration
+	grc time.Duration
+	dia DiagOpts
+	hst *history
+	sub bool
+	tdn time.Duration
+	sto time.Duration
+	rmp map[os.Signal]os.Signal
+	pty bool
+	lim exe.Limits
+	cgt *cgroup.Tree
+	mem *cgroup.Group
+	rso bool
+	rsd time.Duration
+	dwn util.AtomicBool
+	run util.AtomicBool
+	otc chan int
+	fin chan struct{}
 	cmd []string
 }
 
-type muxer map[string]func(*Init, []string)
+// muxer - ipc handlers, the returned string is the response to a request
+type muxer map[string]func(*Init, []string) string
 
 // New - Constructor
 func New(cmd []string, fd string, opts *InitOpts) *Init {
@@ -67,19 +153,66 @@ func New(cmd []string, fd string, opts *InitOpts) *Init {
 
 	ctx, can := context.WithCancel(context.Background())
 
+	var grp func() (*cgroup.Group, error)
+	if opts.Cgroups != nil {
+		grp = opts.Cgroups.Hook(exe.RoleProgram, opts.CgroupLimits)
+	}
+
 	i := &Init{
 		log: log.Logger(),
 		ctx: ctx,
 		can: can,
 		lok: &sync.RWMutex{},
 		rpr: exe.NewReaper(),
-		exc: exe.New(opts.Osusr),
+		exc: exe.NewWithOpts(&exe.ExeOpts{
+			Osusr:      opts.Osusr,
+			Osgrp:      opts.Osgrp,
+			Caps:       opts.Caps,
+			Limits:     opts.Limits,
+			Proxy:      opts.Idle > 0,
+			Role:       exe.RoleProgram,
+			Foreground: opts.Tty,
+			Pty:        opts.Pty,
+			Stdin:      opts.Stdin,
+			Cgroup:     grp,
+		}),
 		syn: sync.Once{},
 		dly: opts.Delay,
+		idl: opts.Idle,
+		grc: opts.Grace,
+		dia: opts.Diags,
+		hst: newhistory(opts.History, opts.HistoryFile),
+		sub: opts.Subreaper,
+		tdn: opts.Teardown,
+		sto: opts.ScriptTimeout,
+		rmp: opts.Remap,
+		pty: opts.Pty,
+		lim: opts.Limits,
+		cgt: opts.Cgroups,
+		rso: opts.RestartOOM,
+		rsd: opts.RestartDelay,
+		otc: make(chan int),
+		fin: make(chan struct{}),
 		cmd: cl,
 	}
 
+	if i.tdn == 0 {
+		i.tdn = DefaultTeardown
+	}
+	if i.rsd == 0 {
+		i.rsd = DefaultRestartDelay
+	}
+	// oom kills are counted in the container's memory cgroup
+	if mem, e := cgroup.Memory(); e == nil {
+		i.mem = mem
+	} else {
+		i.log.Tracef("oom kills are not detected, %s", e.Error())
+	}
+
 	i.sig = signalhandler(i, opts)
+	if opts.Tty && !exe.IsTerminal(0) {
+		i.log.Warn("tty mode, but stdin is not a terminal, run the container with -it")
+	}
 
 	var err error
 	i.ipc, err = ipc.New(fd)
@@ -90,29 +223,55 @@ func New(cmd []string, fd string, opts *InitOpts) *Init {
 	return i
 }
 
-// Close -
+// Close - shuts drinit down, if drinit was started Close returns once the
+// shutdown is complete
 func Close(i *Init) {
 	i.can()
+	if i.run.Get() {
+		<-i.fin
+	}
 }
 
 // Start - Starts the supervised program
 func (i *Init) Start() {
 	i.syn.Do(func() {
+		i.run.Set()
+		i.adopt()
 		i.rpr.Start()
 		if e := i.sig.Start(); e != nil {
 			i.log.Panic(e.Error())
 		}
+		if i.idl > 0 {
+			go i.liveness()
+		}
 		go func(init *Init) {
-			started, _ := init.exc.Start(init.cmd[0], init.cmd[1:]...)
+			started, _ := init.launch(init.exc)
 			ok := <-started
 			if !ok {
 				init.log.Panicf("failed to start program, +%v", init.exc.Info())
 			}
+			init.cur.Set(int32(init.exc.Info().Pid))
 		}(i)
 		i.service()
 	})
 }
 
+// adopt - makes drinit a subreaper if asked to, warns when orphans will
+// not be reparented to drinit
+func (i *Init) adopt() {
+	if i.sub {
+		if e := exe.SetSubreaper(); e != nil {
+			i.log.Errorf("failed to become a subreaper, %s", e.Error())
+		}
+	}
+	if os.Getpid() == 1 {
+		return
+	}
+	if sub, e := exe.IsSubreaper(); e != nil || !sub {
+		i.log.Warnf("drinit is not PID 1 or a subreaper (pid %d), orphans will not be reaped", os.Getpid())
+	}
+}
+
 func (i *Init) service() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Kill)
@@ -126,15 +285,22 @@ func (i *Init) service() {
 		case msg := <-recv:
 			i.log.Tracef("ipc received message %+v", msg)
 			var e error
+			var res string
 			fn, ok := mux[msg.Name]
 			if !ok {
 				e = fmt.Errorf("unknown cmd %s", msg.Name)
 			}
 			if e == nil {
-				fn(i, msg.Args)
+				res = fn(i, msg.Args)
 			} else {
 				i.log.Error(e.Error())
+				res = e.Error() + "\n"
 			}
+			if e = ipc.Respond(msg, res); e != nil {
+				i.log.Error(e.Error())
+			}
+		case code := <-i.otc:
+			i.act(code)
 		case <-c:
 		case <-i.ctx.Done():
 			i.shutdown()
@@ -175,6 +341,12 @@ func (i *Init) signal(sig os.Signal) error {
 	return nil
 }
 
+// pid - the current program's pid, it does not take i.lok so recording a
+// signal never waits on a restart
+func (i *Init) pid() int {
+	return int(i.cur.Get())
+}
+
 // programpid - for testing
 func (i *Init) programpid() int {
 	i.lok.Lock()
@@ -186,10 +358,65 @@ func (i *Init) join() <-chan struct{} {
 	return i.exc.Join()
 }
 
+// launch - starts a generation of the program, its run is added to the
+// history when it ends and the program is restarted if the restart policy
+// says so
+func (i *Init) launch(exc *exe.Exe) (<-chan bool, <-chan exe.Info) {
+	before := i.oomkills()
+	i.hst.started(exc)
+	started, ctx := exc.Start(i.cmd[0], i.cmd[1:]...)
+	go func() {
+		<-exc.Join()
+		inf := exc.Info()
+		if i.oomkilled(inf, before) {
+			i.oom.Incr()
+			i.hst.cause(exc, TriggerOOM)
+			i.log.Warnf("pid %d was killed by the kernel oom killer", inf.Pid)
+		}
+		r := i.hst.done(exc, inf)
+		var cg string
+		if r.CgroupCPU > 0 || r.MemoryPeak > 0 {
+			cg = fmt.Sprintf(", cgroup cpu: %v, memory peak: %s", r.CgroupCPU, kbytes(r.MemoryPeak))
+		}
+		i.log.Infof(
+			"pid %d ended, status: %d, signal: %s, core: %v, trigger: %s, utime: %v, stime: %v, maxrss: %dK%s",
+			r.Pid, r.Status, dash(r.Signal), r.Core, r.Trigger, r.Utime, r.Stime, r.MaxRSS, cg)
+		i.respawn(exc, r)
+	}()
+	return started, ctx
+}
+
+// cause - records what is about to end the current run
+func (i *Init) cause(trigger string) {
+	i.lok.RLock()
+	exc := i.exc
+	i.lok.RUnlock()
+	i.hst.cause(exc, trigger)
+}
+
+// shutdown - stops the program, i.ctx is already done so stopping it is
+// bounded by the teardown period instead
 func (i *Init) shutdown() {
-	_ = stop(i)
+	i.cause(TriggerShutdown)
+	i.lok.RLock()
+	exc := i.exc
+	i.lok.RUnlock()
+	if info := exc.Info(); !(info.Finished.Get() || info.Signaled.Get()) {
+		ctx, can := context.WithTimeout(context.Background(), i.tdn)
+		_ = halt(ctx, i, exc)
+		can()
+	}
+	// the last run reaches the history, and the history file, before
+	// drinit exits
+	select {
+	case <-exc.Join():
+		<-i.hst.recorded(exc)
+	default:
+	}
+	i.teardown()
 	i.sig.Stop()
 	i.ipc.Close()
+	close(i.fin)
 }
 
 func signalhandler(i *Init, opts *InitOpts) *sig.Signalh {
@@ -210,20 +437,77 @@ func signalhandler(i *Init, opts *InitOpts) *sig.Signalh {
 		}
 	}
 
-	sopts := sig.SignalOpts{
-		Trapf: opts.Signf,
-		Fwrdf: func(signal os.Signal) error {
-			if signal == syscall.SIGCHLD {
-				return nil
+	signc := opts.Trapc
+	if signc == nil && opts.Signf != nil {
+		signf := opts.Signf
+		signc = func(_ context.Context, s os.Signal) error {
+			return signf(s)
+		}
+	}
+
+	var trapc sig.Signalc
+	if signc != nil {
+		trapc = func(ctx context.Context, s os.Signal) error {
+			e := signc(ctx, s)
+			if opts.Modes[s] == sig.ModeExitCode && ctx.Err() == nil {
+				return i.outcome(s, e)
 			}
-			return i.signal(signal)
-		},
-		Traps: traps,
+			return e
+		}
+	}
+
+	var ignore []os.Signal
+	if opts.Tty {
+		// drinit is in the background while the program has the terminal
+		ignore = []os.Signal{syscall.SIGTTIN, syscall.SIGTTOU}
+	}
+
+	sopts := sig.SignalOpts{
+		Trapc:    trapc,
+		Fwrdf:    i.forward,
+		Traps:    traps,
+		Modes:    opts.Modes,
+		Rules:    opts.Rules,
+		Depth:    opts.Depth,
+		Timeouts: opts.Timeouts,
+		Ignore:   ignore,
+		Events:   opts.Events,
+		Pidf:     i.pid,
 	}
 	return sig.New(sopts)
 }
 
+// forward - forwards a signal to the program
+func (i *Init) forward(signal os.Signal) error {
+	switch signal {
+	case syscall.SIGCHLD:
+		return nil
+	case syscall.SIGWINCH:
+		// the program's pty is resized, and the kernel signals it
+		if i.pty {
+			return nil
+		}
+	case syscall.SIGTERM:
+		// the container is stopping, shutdown stops the program with the
+		// remapped SIGTERM and tears down whatever is left, it runs in the
+		// service loop so signals received meanwhile are still forwarded
+		i.can()
+		return nil
+	}
+	return i.signal(i.remap(signal))
+}
+
+// remap - translates a signal forwarded to the program
+func (i *Init) remap(signal os.Signal) os.Signal {
+	if s, ok := i.rmp[signal]; ok {
+		i.log.Tracef("remapped %s to %s", sig.SignalToName(signal), sig.SignalToName(s))
+		return s
+	}
+	return signal
+}
+
 func start(i *Init) error {
+	i.dwn.Clear()
 	info := i.exc.Info()
 
 	if info.StartT != 0 && !(info.Finished.Get() || info.Signaled.Get()) {
@@ -236,28 +520,60 @@ func start(i *Init) error {
 
 	time.Sleep(i.dly)
 
-	start, ctx := i.exc.Start(i.cmd[0], i.cmd[1:]...)
+	start, ctx := i.launch(i.exc)
 	ok := <-start
 
 	if !ok {
 		info = <-ctx
 		return fmt.Errorf("+%v", info)
 	}
+	i.cur.Set(int32(i.exc.Info().Pid))
 	return nil
 }
 
 func stop(i *Init) error {
+	// a restart pending under the restart policy is canceled
+	i.dwn.Set()
 	info := i.exc.Info()
 	if info.Finished.Get() || info.Signaled.Get() {
 		return fmt.Errorf("stop failed, process is not running")
 	}
 
 	time.Sleep(i.dly)
-	if err := i.exc.Terminate(); err != nil {
+	return halt(i.ctx, i, i.exc)
+}
+
+// halt - terminates the program and waits for it to exit, if it is still
+// running after the grace period or when ctx is done diagnostics are
+// captured and it is killed
+func halt(ctx context.Context, i *Init, exc *exe.Exe) error {
+	if err := exc.TerminateWith(i.remap(syscall.SIGTERM).(syscall.Signal)); err != nil {
+		return err
+	}
+
+	var grace <-chan time.Time
+	if i.grc > 0 {
+		t := time.NewTimer(i.grc)
+		defer t.Stop()
+		grace = t.C
+	}
+
+	pid := exc.Info().Pid
+	select {
+	case <-exc.Join():
+		return nil
+	case <-grace:
+		i.log.Warnf("pid %d did not exit within %v, killing", pid, i.grc)
+	case <-ctx.Done():
+		i.log.Warnf("pid %d did not exit before %s, killing", pid, ctx.Err().Error())
+	}
+
+	i.diagnose(pid)
+	if err := exc.Kill(); err != nil {
 		return err
 	}
 
-	<-i.join()
+	<-exc.Join()
 	return nil
 }
 
@@ -265,24 +581,21 @@ func restart(i *Init) error {
 	i.lok.Lock()
 	defer i.lok.Unlock()
 
-	wait := i.exc.Join()
-	err := i.exc.Terminate()
-	if err == nil {
-		// if the program has already terminated, we just launch a new one
-		// otherwise, we wait until termination is complete
-		<-wait
-	}
+	// if the program has already terminated, we just launch a new one
+	// otherwise, we wait until termination is complete
+	_ = halt(i.ctx, i, i.exc)
 
 	i.exc = i.exc.Copy()
 	time.Sleep(i.dly)
 
-	start, ctx := i.exc.Start(i.cmd[0], i.cmd[1:]...)
+	start, ctx := i.launch(i.exc)
 	ok := <-start
 
 	if !ok {
 		info := <-ctx
 		return fmt.Errorf("+%v", info)
 	}
+	i.cur.Set(int32(i.exc.Info().Pid))
 	return nil
 }
 
@@ -297,22 +610,60 @@ func sigp(i *Init, sig syscall.Signal) error {
 	return syscall.Kill(-info.Pid, sig)
 }
 
-func runproc(args []string) *exe.Info {
+// scriptopts - drinitctl up and down scripts, their output is returned to
+// drinitctl, they do not read the program's stdin
+func (i *Init) scriptopts() *exe.ExeOpts {
+	opts := &exe.ExeOpts{
+		Role:    exe.RoleScript,
+		Capture: exe.DefaultCapture,
+		Stdin:   exe.StdinNull,
+		Limits:  i.lim,
+	}
+	if i.cgt != nil {
+		opts.Cgroup = i.cgt.Hook(exe.RoleScript, cgroup.Limits{})
+	}
+	return opts
+}
+
+func runproc(ctx context.Context, opts *exe.ExeOpts, args []string) *exe.Info {
 	sz := len(args)
 	if sz > 0 {
 		switch sz {
 		case 1:
-			return exe.New(nil).Run(args[0])
+			return exe.NewWithOpts(opts).RunContext(ctx, args[0])
 		default:
-			return exe.New(nil).Run(args[0], args[1:]...)
+			return exe.NewWithOpts(opts).RunContext(ctx, args[0], args[1:]...)
 		}
 	}
 	return nil
 }
 
+// script - runs a drinitctl up or down script, the response is its output,
+// the script is stopped after the script timeout or when drinit shuts down
+func script(i *Init, args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	ctx, can := i.ctx, context.CancelFunc(func() {})
+	if i.sto > 0 {
+		ctx, can = context.WithTimeout(i.ctx, i.sto)
+	}
+	defer can()
+	info := runproc(ctx, i.scriptopts(), args)
+	if info.Error != nil {
+		msg := info.Error.Error()
+		if ctx.Err() == context.DeadlineExceeded {
+			msg = fmt.Sprintf("timed out after %v, %s", i.sto, msg)
+		}
+		i.log.Errorf("script %s failed, %s%s", strings.Join(args, " "), msg, info.Captured())
+		return info.Output + msg + "\n"
+	}
+	return info.Output
+}
+
 func newmuxer() muxer {
 	mux := make(muxer)
-	mux[ipc.Signal] = func(i *Init, args []string) {
+	mux[ipc.Signal] = func(i *Init, args []string) string {
 		s := ""
 		if len(args) == 1 {
 			s = args[0]
@@ -320,35 +671,59 @@ func newmuxer() muxer {
 		sign, e := sig.ToSignal(s)
 		if e != nil {
 			i.log.Error(e.Error())
+			return e.Error() + "\n"
 		}
 		if e = sigp(i, sign.(syscall.Signal)); e != nil {
 			i.log.Error(e.Error())
+			return e.Error() + "\n"
 		}
+		return ""
 	}
-	mux[ipc.Up] = func(i *Init, args []string) {
+	mux[ipc.Up] = func(i *Init, args []string) string {
 		if e := start(i); e != nil {
 			i.log.Error(e.Error())
 		}
-		if len(args) > 0 {
-			if info := runproc(args); info.Error != nil {
-				i.log.Error(info.Error.Error())
-			}
-		}
+		return script(i, args)
 	}
-	mux[ipc.Down] = func(i *Init, args []string) {
-		if len(args) > 0 {
-			if info := runproc(args); info.Error != nil {
-				i.log.Error(info.Error.Error())
-			}
-		}
+	mux[ipc.Down] = func(i *Init, args []string) string {
+		res := script(i, args)
+		i.cause(TriggerIPC)
 		if e := stop(i); e != nil {
 			i.log.Error(e.Error())
 		}
+		return res
 	}
-	mux[ipc.Cycle] = func(i *Init, args []string) {
+	mux[ipc.Cycle] = func(i *Init, args []string) string {
+		i.cause(TriggerIPC)
 		if e := restart(i); e != nil {
 			i.log.Error(e.Error())
 		}
+		return ""
+	}
+	mux[ipc.Stdin] = func(i *Init, args []string) string {
+		i.lok.RLock()
+		exc := i.exc
+		i.lok.RUnlock()
+		if _, e := exc.Write([]byte(strings.Join(args, " ") + "\n")); e != nil {
+			i.log.Error(e.Error())
+			return e.Error() + "\n"
+		}
+		return ""
+	}
+	mux[ipc.History] = func(i *Init, args []string) string {
+		return i.hst.String()
+	}
+	mux[ipc.Status] = func(i *Init, args []string) string {
+		return i.status()
+	}
+	mux[ipc.Metrics] = func(i *Init, args []string) string {
+		return i.metrics()
+	}
+	mux[ipc.Ps] = func(i *Init, args []string) string {
+		return i.ps()
+	}
+	mux[ipc.Signals] = func(i *Init, args []string) string {
+		return i.signals()
 	}
 	return mux
 }
@@ -19,23 +19,30 @@ limitations under the License.
 package ini
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/streamz/drinit/cgroup"
+	"github.com/streamz/drinit/exe"
 	"github.com/streamz/drinit/ipc"
+	"github.com/streamz/drinit/proc"
+	"github.com/streamz/drinit/sig"
 	"github.com/streamz/drinit/util"
 	"github.com/stretchr/testify/assert"
 )
 
 var (
 	_, b, _, _ = runtime.Caller(0)
-	Testdata = strings.TrimSuffix(filepath.Dir(b), "/ini") + "/testdata/"
+	Testdata   = strings.TrimSuffix(filepath.Dir(b), "/ini") + "/testdata/"
 )
 
 func TestStop(t *testing.T) {
@@ -64,6 +71,124 @@ func TestStop(t *testing.T) {
 	Close(i)
 }
 
+func TestScriptOutput(t *testing.T) {
+	f := "/tmp/drinit-test-script.pipe"
+	i := New(
+		[]string{Testdata + "service.sh"},
+		f,
+		&InitOpts{})
+
+	completer := i.join()
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	res, err := ipc.Request(f, ipc.Msg{
+		Name: ipc.Down,
+		Args: []string{Testdata + "drain.sh"},
+	}, 5*time.Second)
+	<-completer
+
+	assert.NoError(t, err)
+	assert.Contains(t, res, "draining")
+	assert.Contains(t, res, "exit status 2")
+	Close(i)
+}
+
+func TestScriptTimeout(t *testing.T) {
+	f := "/tmp/drinit-test-script-timeout.pipe"
+	i := New(
+		[]string{Testdata + "service.sh"},
+		f,
+		&InitOpts{ScriptTimeout: 200 * time.Millisecond})
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	res, err := ipc.Request(f, ipc.Msg{
+		Name: ipc.Up,
+		Args: []string{"sleep", "30"},
+	}, 5*time.Second)
+
+	assert.NoError(t, err)
+	assert.Contains(t, res, "timed out after 200ms")
+	for _, r := range exe.Roles() {
+		assert.NotEqual(t, exe.RoleScript, r, "the script should be stopped")
+	}
+	Close(i)
+}
+
+func TestScriptLimits(t *testing.T) {
+	nice := 5
+	f := "/tmp/drinit-test-script-limits.pipe"
+	i := New(
+		[]string{Testdata + "service.sh"},
+		f,
+		&InitOpts{Limits: exe.Limits{Nice: &nice}})
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	// a script runs with the program's limits
+	res, err := ipc.Request(f, ipc.Msg{Name: ipc.Up, Args: []string{"nice"}}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "5\n", res)
+	Close(i)
+}
+
+func TestStdin(t *testing.T) {
+	f := "/tmp/drinit-test-stdin.pipe"
+	i := New(
+		[]string{"head", "-n", "1"},
+		f,
+		&InitOpts{Stdin: exe.StdinPipe})
+
+	completer := i.join()
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	res, err := ipc.Request(f, ipc.Msg{
+		Name: ipc.Stdin,
+		Args: []string{"hello", "world"},
+	}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "", res)
+
+	select {
+	case <-completer:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the program should exit after reading a line")
+	}
+	assert.Equal(t, 0, i.exc.Info().Exit)
+
+	res, err = ipc.Request(f, ipc.Msg{Name: ipc.Stdin, Args: []string{"late"}}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "not running")
+	Close(i)
+}
+
+func TestRemap(t *testing.T) {
+	i := New(
+		[]string{Testdata + "service.sh"},
+		"/tmp/drinit-test-remap.pipe",
+		&InitOpts{
+			Remap: map[os.Signal]os.Signal{syscall.SIGTERM: syscall.SIGUSR1},
+		})
+
+	completer := i.join()
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	stop(i)
+	<-completer
+
+	info := i.exc.Info()
+	assert.Equal(t, syscall.SIGUSR1, info.Signal, "should be stopped with SIGUSR1")
+	Close(i)
+}
+
 func TestStopByPipe(t *testing.T) {
 	f := "/tmp/drinit-test-stop-pipe.pipe"
 	i := New(
@@ -173,9 +298,597 @@ func TestSignal(t *testing.T) {
 	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
 
 	w.Wait()
+	assert.Contains(t, i.metrics(), `drinit_signals_trapped_total{signal="SIGUSR1"} 1`)
+	time.Sleep(100 * time.Millisecond)
+	events := i.signals()
+	assert.Contains(t, events, "SIGUSR1")
+	assert.Contains(t, events, sig.ActionTrap)
 	stop(i)
 	<-joiner
 
 	assert.True(t, b.Get(), "should be true")
 	Close(i)
 }
+
+func TestSignalDuringRestart(t *testing.T) {
+	b := &util.AtomicBool{}
+	i := New(
+		[]string{Testdata + "stubborn.sh"},
+		"/tmp/drinit-test-sigrestart.pipe",
+		&InitOpts{
+			Traps: []string{"SIGUSR1"},
+			Signf: func(s os.Signal) error {
+				b.Set()
+				return nil
+			},
+		})
+
+	go i.Start()
+	time.Sleep(500 * time.Millisecond)
+	pid := i.pid()
+
+	// with no grace period the restart holds i.lok until drinit shuts down
+	go restart(i)
+	time.Sleep(500 * time.Millisecond)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	assert.Eventually(t, b.Get, 2*time.Second, 50*time.Millisecond, "the trap should run")
+
+	assert.Eventually(t, func() bool {
+		return len(i.sig.Events()) > 0
+	}, 2*time.Second, 50*time.Millisecond, "the signal should be recorded")
+	for _, e := range i.sig.Events() {
+		assert.Equal(t, pid, e.Pid)
+	}
+	Close(i)
+}
+
+func TestTrapOutcome(t *testing.T) {
+	i := New(
+		[]string{Testdata + "service.sh"},
+		"/tmp/drinit-test-outcome.pipe",
+		&InitOpts{
+			Traps: []string{"SIGUSR2"},
+			Modes: map[os.Signal]sig.Mode{syscall.SIGUSR2: sig.ModeExitCode},
+			Signf: func(s os.Signal) error {
+				// the trap script asks for a cycle
+				return &exe.ExitError{WaitStatus: syscall.WaitStatus(OutcomeCycle << 8)}
+			},
+		})
+
+	joiner := i.join()
+
+	go i.Start()
+	time.Sleep(time.Second)
+	oldpid := i.programpid()
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	<-joiner
+	time.Sleep(500 * time.Millisecond)
+
+	assert.NotEqual(t, oldpid, i.programpid(), "the program should be cycled")
+	runs := i.hst.list()
+	assert.Equal(t, 1, len(runs), "should record one run")
+	if len(runs) == 1 {
+		assert.Equal(t, TriggerTrap, runs[0].Trigger)
+	}
+
+	stop(i)
+	Close(i)
+}
+
+func TestTrapOutcomePending(t *testing.T) {
+	n := &util.AtomicInt32{}
+	i := New(
+		[]string{Testdata + "stubborn.sh"},
+		"/tmp/drinit-test-outcomepending.pipe",
+		&InitOpts{
+			Traps: []string{"SIGUSR2"},
+			Modes: map[os.Signal]sig.Mode{syscall.SIGUSR2: sig.ModeExitCode},
+			Signf: func(s os.Signal) error {
+				n.Incr()
+				return &exe.ExitError{WaitStatus: syscall.WaitStatus(OutcomeCycle << 8)}
+			},
+		})
+
+	go i.Start()
+	time.Sleep(500 * time.Millisecond)
+
+	// the program ignores SIGTERM and there is no grace period, so the
+	// cycle is pending until drinit shuts down
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	time.Sleep(500 * time.Millisecond)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+
+	assert.Eventually(t, func() bool {
+		return n.Get() == 2
+	}, 2*time.Second, 50*time.Millisecond, "the trap should run while the cycle is pending")
+	Close(i)
+}
+
+func TestTrapOutcomeExit(t *testing.T) {
+	i := New(
+		[]string{Testdata + "service.sh"},
+		"/tmp/drinit-test-outcomeexit.pipe",
+		&InitOpts{
+			Traps: []string{"SIGUSR2"},
+			Modes: map[os.Signal]sig.Mode{syscall.SIGUSR2: sig.ModeExitCode},
+			Signf: func(s os.Signal) error {
+				return &exe.ExitError{WaitStatus: syscall.WaitStatus(OutcomeExit << 8)}
+			},
+		})
+
+	done := make(chan struct{})
+	go func() {
+		i.Start()
+		close(done)
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "drinit should shut down")
+	}
+	Close(i)
+}
+
+func TestTrapForward(t *testing.T) {
+	i := New(
+		[]string{Testdata + "service.sh"},
+		"/tmp/drinit-test-trapforward.pipe",
+		&InitOpts{
+			Traps: []string{"SIGUSR2"},
+			Modes: map[os.Signal]sig.Mode{syscall.SIGUSR2: sig.ModeExitCode},
+			Signf: func(s os.Signal) error {
+				// the trap script hands the signal on to the program
+				return &exe.ExitError{WaitStatus: syscall.WaitStatus(OutcomeForward << 8)}
+			},
+		})
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	time.Sleep(500 * time.Millisecond)
+
+	stats := i.sig.Stats()[syscall.SIGUSR2]
+	assert.Equal(t, 1, stats.Trapped)
+	assert.Equal(t, 1, stats.Forwarded, "the forward should be counted")
+	assert.Contains(t, i.metrics(), `drinit_signals_forwarded_total{signal="SIGUSR2"} 1`)
+	assert.Contains(t, i.signals(), sig.ActionForward)
+
+	stop(i)
+	Close(i)
+}
+
+func TestPty(t *testing.T) {
+	f, _ := ioutil.TempFile("", "drinit-pty")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	i := New(
+		[]string{"sh", "-c", "test -t 0 && test -t 1 && tty > " + f.Name() + "; exec sleep 10000"},
+		"/tmp/drinit-test-pty.pipe",
+		&InitOpts{Pty: true})
+
+	joiner := i.join()
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	b, _ := ioutil.ReadFile(f.Name())
+	assert.Contains(t, string(b), "/dev/pts/", "the program should run on a pty")
+
+	stop(i)
+	<-joiner
+	info := i.exc.Info()
+	assert.True(t, info.Signaled.Get(), "the program should be stopped")
+	Close(i)
+}
+
+func TestIdleRestart(t *testing.T) {
+	i := New(
+		[]string{Testdata + "service.sh"},
+		"/tmp/drinit-test-idle.pipe",
+		&InitOpts{Idle: time.Second})
+
+	joiner := i.join()
+
+	go i.Start()
+	time.Sleep(500 * time.Millisecond)
+	oldpid := i.programpid()
+
+	// service.sh only writes once on startup
+	<-joiner
+	time.Sleep(500 * time.Millisecond)
+
+	assert.NotEqual(t, oldpid, i.programpid(), "pids should not be equal")
+	stop(i)
+	Close(i)
+}
+
+func TestStopGrace(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "drinit-diag")
+	defer os.RemoveAll(dir)
+
+	i := New(
+		[]string{Testdata + "stubborn.sh"},
+		"/tmp/drinit-test-grace.pipe",
+		&InitOpts{
+			Grace: time.Second,
+			Diags: DiagOpts{Dir: dir, Wait: 100 * time.Millisecond},
+		})
+
+	go i.Start()
+	time.Sleep(500 * time.Millisecond)
+	pid := i.programpid()
+
+	err := stop(i)
+	assert.NoError(t, err)
+
+	info := i.exc.Info()
+	assert.True(t, info.Signaled.Get(), "info should be Signaled")
+	assert.Equal(t, 9, info.Exit, "should exit with 9")
+
+	caps, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(caps), "should capture diagnostics once")
+	if len(caps) == 1 {
+		status := filepath.Join(dir, caps[0].Name(), strconv.Itoa(pid), "status")
+		_, e := os.Stat(status)
+		assert.NoError(t, e, "should capture the program's status")
+	}
+	Close(i)
+}
+
+func TestIdleDiagnose(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "drinit-diag")
+	defer os.RemoveAll(dir)
+
+	i := New(
+		[]string{Testdata + "stubborn.sh"},
+		"/tmp/drinit-test-idlediag.pipe",
+		&InitOpts{
+			Idle:  time.Second,
+			Grace: 500 * time.Millisecond,
+			Diags: DiagOpts{Dir: dir, Wait: 100 * time.Millisecond},
+		})
+
+	go i.Start()
+	time.Sleep(500 * time.Millisecond)
+	oldpid := i.programpid()
+
+	assert.Eventually(t, func() bool {
+		pid := i.programpid()
+		return pid != 0 && pid != oldpid
+	}, 5*time.Second, 50*time.Millisecond, "the idle program should be restarted")
+
+	caps, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(caps), "should capture diagnostics once")
+
+	Close(i)
+}
+
+func TestHistoryCause(t *testing.T) {
+	h := newhistory(0, "")
+
+	// a program that is not running has no run to end
+	ended := exe.New(nil)
+	h.cause(ended, TriggerIPC)
+	assert.Empty(t, h.why)
+
+	exc := exe.New(nil)
+	h.started(exc)
+	h.cause(exc, TriggerIPC)
+	h.cause(exc, TriggerShutdown)
+	r := h.done(exc, exe.Info{})
+	assert.Equal(t, TriggerIPC, r.Trigger, "the first cause should win")
+	assert.Empty(t, h.why)
+}
+
+func TestHistory(t *testing.T) {
+	f := "/tmp/drinit-test-history.pipe"
+	i := New(
+		[]string{Testdata + "service.sh"},
+		f,
+		&InitOpts{})
+
+	joiner := i.join()
+
+	go i.Start()
+	time.Sleep(time.Second)
+	pid := i.programpid()
+
+	res, err := ipc.Request(f, ipc.Msg{Name: ipc.Cycle}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "", res)
+	<-joiner
+
+	// the run is recorded once the old generation has been joined
+	time.Sleep(100 * time.Millisecond)
+	runs := i.hst.list()
+	assert.Equal(t, 1, len(runs), "should record one run")
+	if len(runs) == 1 {
+		assert.Equal(t, pid, runs[0].Pid)
+		assert.Equal(t, TriggerIPC, runs[0].Trigger)
+		assert.NotEqual(t, int64(0), runs[0].EndT)
+	}
+
+	res, err = ipc.Request(f, ipc.Msg{Name: ipc.History}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, strconv.Itoa(pid))
+	assert.Contains(t, res, TriggerIPC)
+
+	res, err = ipc.Request(f, ipc.Msg{Name: ipc.Status}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "running")
+	assert.Contains(t, res, strconv.Itoa(i.programpid()))
+	assert.Contains(t, res, "orphans:")
+
+	res, err = ipc.Request(f, ipc.Msg{Name: ipc.Metrics}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "drinit_program_up 1")
+	assert.Contains(t, res, "drinit_orphans_reaped_total")
+
+	res, err = ipc.Request(f, ipc.Msg{Name: ipc.Ps}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "ROLE")
+	assert.Contains(t, res, strconv.Itoa(i.programpid()))
+	assert.Contains(t, res, exe.RoleProgram)
+
+	stop(i)
+	Close(i)
+}
+
+func TestHistoryFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "drinit-history")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.json")
+
+	h := newhistory(2, file)
+	for n := 1; n <= 3; n++ {
+		h.done(nil, exe.Info{Pid: n, Exit: n})
+	}
+	assert.Equal(t, 2, len(h.list()), "history should be bounded")
+
+	h = newhistory(2, file)
+	runs := h.list()
+	assert.Equal(t, 2, len(runs), "history should be loaded")
+	assert.Equal(t, 2, runs[0].Pid)
+	assert.Equal(t, 3, runs[1].Pid)
+	assert.Equal(t, TriggerCrash, runs[1].Trigger)
+}
+
+func TestTeardown(t *testing.T) {
+	i := New(
+		[]string{Testdata + "daemon.sh"},
+		"/tmp/drinit-test-teardown.pipe",
+		&InitOpts{
+			Subreaper: true,
+			Teardown:  time.Second,
+		})
+
+	done := make(chan struct{})
+	go func() {
+		i.Start()
+		close(done)
+	}()
+	time.Sleep(time.Second)
+
+	var daemon int
+	kids, _ := proc.Descendants(os.Getpid())
+	for _, k := range kids {
+		if k.Sid != i.programpid() && strings.Contains(proc.Cmdline(k.Pid), "trap") {
+			daemon = k.Pid
+		}
+	}
+	assert.NotEqual(t, 0, daemon, "daemon should be running")
+
+	// a forwarded SIGTERM shuts drinit down
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	<-done
+
+	st, err := proc.ReadStat(daemon)
+	assert.True(t, err != nil || st.State == "Z", "daemon should be killed")
+	assert.Empty(t, living(), "nothing should be left running")
+}
+
+func TestTeardownRemap(t *testing.T) {
+	// the program and its daemon only stop on SIGUSR1
+	stopper := "trap '' TERM; trap 'exit 0' USR1; while :; do sleep 0.1; done"
+	i := New(
+		[]string{"sh", "-c", "setsid sh -c \"" + stopper + "\" & " + stopper},
+		"/tmp/drinit-test-teardown-remap.pipe",
+		&InitOpts{
+			Subreaper: true,
+			Teardown:  3 * time.Second,
+			Remap:     map[os.Signal]os.Signal{syscall.SIGTERM: syscall.SIGUSR1},
+		})
+
+	done := make(chan struct{})
+	go func() {
+		i.Start()
+		close(done)
+	}()
+	time.Sleep(time.Second)
+	assert.True(t, len(living()) >= 2, "the daemon should be running")
+
+	now := time.Now()
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	<-done
+
+	assert.True(t, time.Since(now) < 2*time.Second, "the daemon should stop without being killed")
+	assert.Empty(t, living(), "nothing should be left running")
+}
+
+func TestShutdownForwards(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "usr1")
+	i := New(
+		[]string{"sh", "-c", "trap '' TERM; trap 'echo usr1 > " + out + "' USR1; while :; do sleep 0.1; done"},
+		"/tmp/drinit-test-shutdown-forwards.pipe",
+		&InitOpts{Teardown: 2 * time.Second})
+
+	done := make(chan struct{})
+	go func() {
+		i.Start()
+		close(done)
+	}()
+	time.Sleep(time.Second)
+
+	// the program ignores SIGTERM, so the shutdown waits for the teardown
+	// period, signals received meanwhile are still forwarded
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	time.Sleep(200 * time.Millisecond)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	time.Sleep(500 * time.Millisecond)
+
+	b, err := ioutil.ReadFile(out)
+	assert.NoError(t, err, "SIGUSR1 should reach the program during the shutdown")
+	assert.Equal(t, "usr1\n", string(b))
+	assert.Equal(t, 1, i.sig.Stats()[syscall.SIGUSR1].Forwarded)
+
+	select {
+	case <-done:
+		t.Fatal("the shutdown should still be waiting for the program")
+	default:
+	}
+	<-done
+}
+
+func TestCgroups(t *testing.T) {
+	mnt := cgroup.Mount()
+	if len(mnt) == 0 {
+		t.Skip("cgroup v2 is not mounted")
+	}
+	dir := filepath.Join(mnt, fmt.Sprintf("drinit-ini-test-%d", os.Getpid()))
+	if e := os.Mkdir(dir, 0755); e != nil {
+		t.Skipf("cgroup v2 is not writable, %s", e.Error())
+	}
+	tree, e := cgroup.NewTree(dir)
+	assert.NoError(t, e)
+	defer cgroup.Open(dir).Remove()
+
+	// the sleep left behind by the subshell is not the program's child
+	f := "/tmp/drinit-test-cgroups.pipe"
+	i := New(
+		[]string{"sh", "-c", "(setsid sleep 61 &); exec sleep 60"},
+		f,
+		&InitOpts{Cgroups: tree, Teardown: time.Second})
+
+	done := make(chan struct{})
+	go func() {
+		i.Start()
+		close(done)
+	}()
+	time.Sleep(time.Second)
+
+	res, err := ipc.Request(f, ipc.Msg{Name: ipc.Status}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, filepath.Join(dir, "program.1"))
+	assert.Len(t, tree.Procs(), 2)
+
+	// scripts run in their own group
+	res, err = ipc.Request(f, ipc.Msg{Name: ipc.Up, Args: []string{"grep", "^0::", "/proc/self/cgroup"}}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "/script.2")
+
+	Close(i)
+	<-done
+	assert.Empty(t, tree.Procs(), "nothing should be left in the cgroups")
+	assert.Empty(t, tree.Groups())
+	runs := i.hst.list()
+	if assert.Len(t, runs, 1) {
+		assert.True(t, runs[0].CgroupCPU > 0)
+	}
+}
+
+func TestRestartCrash(t *testing.T) {
+	// only oom kills are restarted, a crash stays down
+	i := New(
+		[]string{"sh", "-c", "sleep 0.2; exit 3"},
+		"/tmp/drinit-test-restart.pipe",
+		&InitOpts{RestartOOM: true, RestartDelay: 100 * time.Millisecond})
+
+	go i.Start()
+	time.Sleep(time.Second)
+
+	runs := i.hst.list()
+	if assert.Len(t, runs, 1, "a crash should not be restarted") {
+		assert.Equal(t, TriggerCrash, runs[0].Trigger)
+	}
+	assert.Equal(t, 0, i.rsc.Get())
+	Close(i)
+}
+
+// oomgroup - a memory cgroup limited to 32M, skips the test when drinit
+// cannot create one
+func oomgroup(t *testing.T) *cgroup.Group {
+	mem, e := cgroup.Memory()
+	if e != nil {
+		t.Skipf("no memory cgroup, %s", e.Error())
+	}
+	g := cgroup.Open(filepath.Join(mem.Path, fmt.Sprintf("drinit-oom-test-%d", os.Getpid())))
+	if e := os.Mkdir(g.Path, 0755); e != nil {
+		t.Skipf("the memory cgroup is not writable, %s", e.Error())
+	}
+	if g.Write("memory.max", "32M") != nil && g.Write("memory.limit_in_bytes", "32M") != nil {
+		g.Remove()
+		t.Skip("the memory cgroup cannot be limited")
+	}
+	// without swap the limit is reached
+	g.Write("memory.swap.max", "0")
+	g.Write("memory.memsw.limit_in_bytes", "32M")
+	return g
+}
+
+func TestOOM(t *testing.T) {
+	g := oomgroup(t)
+	defer g.Remove()
+
+	// without --restart-oom an oom killed program stays down
+	f := "/tmp/drinit-test-oom.pipe"
+	hog := []string{"sh", "-c", "echo $$ > " + g.Path + "/cgroup.procs; x=0123456789; while :; do x=$x$x; done"}
+	i := New(hog, f, &InitOpts{RestartDelay: 100 * time.Millisecond})
+	i.mem = g
+
+	go i.Start()
+	select {
+	case <-i.join():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the program should be oom killed")
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	runs := i.hst.list()
+	if assert.Len(t, runs, 1, "an oom kill should not be restarted") {
+		assert.Equal(t, TriggerOOM, runs[0].Trigger)
+		assert.Equal(t, "SIGKILL", runs[0].Signal)
+	}
+	res, err := ipc.Request(f, ipc.Msg{Name: ipc.Metrics}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "drinit_program_oom_kills_total 1\n")
+	assert.Contains(t, res, "drinit_program_restarts_total 0\n")
+	res, err = ipc.Request(f, ipc.Msg{Name: ipc.History}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Contains(t, res, " oom ")
+	Close(i)
+
+	// a SIGKILL that is not an oom kill is a crash
+	assert.False(t, i.oomkilled(exe.Info{Signal: syscall.SIGKILL}, i.oomkills()))
+
+	// with --restart-oom it is restarted
+	i = New(hog, f, &InitOpts{RestartOOM: true, RestartDelay: 100 * time.Millisecond})
+	i.mem = g
+
+	go i.Start()
+	assert.Eventually(t, func() bool {
+		return i.rsc.Get() >= 1
+	}, 10*time.Second, 50*time.Millisecond, "an oom kill should be restarted")
+	Close(i)
+	for _, r := range i.hst.list() {
+		if r.Trigger != TriggerShutdown {
+			assert.Equal(t, TriggerOOM, r.Trigger)
+		}
+	}
+}
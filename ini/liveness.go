@@ -0,0 +1,66 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"time"
+)
+
+// liveness - restarts the program when it has not written to stdout or
+// stderr for the configured idle duration
+func (i *Init) liveness() {
+	period := i.idl / 4
+	if period > time.Second {
+		period = time.Second
+	}
+
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if pid, idle := i.idle(); idle {
+				i.log.Warnf("pid %d wrote no output for %v, restarting", pid, i.idl)
+				i.cause(TriggerProbe)
+				if e := restart(i); e != nil {
+					i.log.Error(e.Error())
+				}
+			}
+		case <-i.ctx.Done():
+			return
+		}
+	}
+}
+
+func (i *Init) idle() (int, bool) {
+	i.lok.RLock()
+	inf := i.exc.Info()
+	i.lok.RUnlock()
+
+	if inf.StartT == 0 || inf.Finished.Get() || inf.Signaled.Get() {
+		return inf.Pid, false
+	}
+
+	last := inf.StartT
+	if inf.OutT > last {
+		last = inf.OutT
+	}
+	return inf.Pid, time.Since(time.Unix(0, last)) > i.idl
+}
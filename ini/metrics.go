@@ -0,0 +1,125 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/streamz/drinit/cgroup"
+	"github.com/streamz/drinit/sig"
+)
+
+// metrics - drinit's counters in the prometheus text format
+func (i *Init) metrics() string {
+	i.lok.RLock()
+	inf := i.exc.Info()
+	i.lok.RUnlock()
+
+	up := 0
+	if inf.StartT != 0 && inf.EndT == 0 {
+		up = 1
+	}
+
+	var sb strings.Builder
+	metric(&sb, "drinit_program_up", "gauge",
+		"1 if the supervised program is running", up)
+	metric(&sb, "drinit_program_runs", "gauge",
+		"runs of the supervised program in the history", len(i.hst.list()))
+	metric(&sb, "drinit_program_restarts_total", "counter",
+		"restarts of the program after an oom kill", i.rsc.Get())
+	metric(&sb, "drinit_program_oom_kills_total", "counter",
+		"runs of the program ended by the kernel's oom killer", i.oom.Get())
+	if len(inf.Cgroup) > 0 {
+		st := cgroup.Open(inf.Cgroup).Stats()
+		if inf.Usage != nil {
+			st = *inf.Usage
+		}
+		metric(&sb, "drinit_program_cgroup_cpu_seconds_total", "counter",
+			"cpu time used by the program's cgroup", st.CPU.Seconds())
+		metric(&sb, "drinit_program_cgroup_memory_bytes", "gauge",
+			"memory used by the program's cgroup", st.Memory)
+		metric(&sb, "drinit_program_cgroup_memory_peak_bytes", "gauge",
+			"peak memory used by the program's cgroup", st.MemoryPeak)
+		metric(&sb, "drinit_program_cgroup_pids", "gauge",
+			"processes and threads in the program's cgroup", st.Pids)
+	}
+
+	rs := i.rpr.Stats()
+	metric(&sb, "drinit_reaped_total", "counter",
+		"children reaped by drinit", rs.Reaped)
+	metric(&sb, "drinit_orphans_reaped_total", "counter",
+		"orphaned children reaped by drinit", rs.Orphans)
+	metric(&sb, "drinit_orphans_reaped_last_minute", "gauge",
+		"orphaned children reaped in the last minute", rs.LastMinute)
+	metric(&sb, "drinit_zombies", "gauge",
+		"exited children of drinit that are not yet reaped", zombies())
+
+	ss := i.sig.Stats()
+	for _, c := range []struct {
+		name, help string
+		get        func(sig.Counters) int
+	}{
+		{"drinit_signals_received_total", "signals received by drinit",
+			func(c sig.Counters) int { return c.Received }},
+		{"drinit_signals_forwarded_total", "signals forwarded to the program",
+			func(c sig.Counters) int { return c.Forwarded }},
+		{"drinit_signals_trapped_total", "trap runs for a signal",
+			func(c sig.Counters) int { return c.Trapped }},
+		{"drinit_signals_coalesced_total", "signals merged into a pending trap",
+			func(c sig.Counters) int { return c.Coalesced }},
+		{"drinit_signals_dropped_total", "signals dropped because the trap queue was full",
+			func(c sig.Counters) int { return c.Dropped }},
+		{"drinit_signals_skipped_total", "signals skipped because their trap was running",
+			func(c sig.Counters) int { return c.Skipped }},
+		{"drinit_traps_restarted_total", "trap runs canceled and restarted by a later signal",
+			func(c sig.Counters) int { return c.Restarted }},
+		{"drinit_traps_timed_out_total", "trap runs killed by their timeout",
+			func(c sig.Counters) int { return c.TimedOut }},
+	} {
+		values := make(map[string]int, len(ss))
+		for s, cnt := range ss {
+			values[sig.SignalToName(s)] = c.get(cnt)
+		}
+		labeled(&sb, c.name, "counter", c.help, "signal", values)
+	}
+	return sb.String()
+}
+
+// labeled - a metric with one label, values are written in label order
+func labeled(w io.Writer, name, kind, help, label string, values map[string]int) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+func metric(w io.Writer, name, kind, help string, v interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(w, "%s %v\n", name, v)
+}
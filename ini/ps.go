@@ -0,0 +1,104 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/streamz/drinit/exe"
+	"github.com/streamz/drinit/proc"
+)
+
+// ticks - USER_HZ, the unit of utime and stime in /proc/<pid>/stat
+const ticks = 100
+
+// roles reported by ps in addition to the exe roles
+const (
+	roleDrinit = "drinit"
+	// roleOrphan - a process reparented to drinit
+	roleOrphan = "orphan"
+)
+
+// ps - lists every process under drinit, children inherit the role of
+// the nearest ancestor drinit knows about
+func (i *Init) ps() string {
+	self := os.Getpid()
+	tree, e := proc.Tree(self)
+	if e != nil {
+		return e.Error() + "\n"
+	}
+
+	known := exe.Roles()
+	roles := make(map[int]string, len(tree))
+	users := make(map[int]string)
+	page := int64(os.Getpagesize() / 1024)
+
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tPPID\tPGID\tSTATE\tUSER\tRSS\tTIME\tROLE\tCOMMAND")
+	for _, st := range tree {
+		role, ok := known[st.Pid]
+		switch {
+		case ok:
+		case st.Pid == self:
+			role = roleDrinit
+		case st.Ppid == self:
+			role = roleOrphan
+		default:
+			role = roles[st.Ppid]
+		}
+		roles[st.Pid] = role
+
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%dK\t%s\t%s\t%s\n",
+			st.Pid, st.Ppid, st.Pgid, st.State, username(users, st.Pid),
+			st.Rss*page, cputime(st.Utime+st.Stime), role, proc.Cmdline(st.Pid))
+	}
+	w.Flush()
+	return sb.String()
+}
+
+func username(cache map[int]string, pid int) string {
+	uid, e := proc.Uid(pid)
+	if e != nil {
+		return "?"
+	}
+	if name, ok := cache[uid]; ok {
+		return name
+	}
+	name := strconv.Itoa(uid)
+	if u, e := user.LookupId(name); e == nil {
+		name = u.Username
+	}
+	cache[uid] = name
+	return name
+}
+
+// cputime - formats clock ticks as [hh:]mm:ss
+func cputime(t uint64) string {
+	s := t / ticks
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, (s/60)%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
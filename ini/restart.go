@@ -0,0 +1,86 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/streamz/drinit/exe"
+)
+
+// DefaultRestartDelay - how long drinit waits before restarting the program
+const DefaultRestartDelay = time.Second
+
+// respawn - starts the program again after the kernel's oom killer killed
+// it, when drinit is asked to, a program that ends otherwise stays down
+func (i *Init) respawn(exc *exe.Exe, run Run) {
+	if run.Trigger != TriggerOOM || !i.rso {
+		return
+	}
+
+	i.log.Infof("pid %d was oom killed, restarting in %v", run.Pid, i.rsd)
+	t := time.NewTimer(i.rsd)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-i.ctx.Done():
+		return
+	}
+
+	// drinitctl may have started a new generation, or stopped the
+	// program, in the meantime
+	i.lok.RLock()
+	current := i.exc == exc
+	i.lok.RUnlock()
+	if !current || i.dwn.Get() {
+		i.log.Infof("restart of pid %d canceled", run.Pid)
+		return
+	}
+	i.rsc.Incr()
+	if e := start(i); e != nil {
+		i.log.Errorf("restart failed, %s", e.Error())
+	}
+}
+
+// oomkilled - the run was ended by the kernel's oom killer, the program
+// was killed by a SIGKILL drinit did not send while an oom kill was counted
+// in its own cgroup, or in the container's since it started
+func (i *Init) oomkilled(inf exe.Info, before int64) bool {
+	if inf.Signal != syscall.SIGKILL || inf.Signaled.Get() {
+		return false
+	}
+	if inf.Usage != nil && inf.Usage.OOMKills > 0 {
+		return true
+	}
+	return before >= 0 && i.oomkills() > before
+}
+
+// oomkills - the oom kill count of the container's memory cgroup, -1 when
+// it is not known
+func (i *Init) oomkills() int64 {
+	if i.mem == nil {
+		return -1
+	}
+	n, ok := i.mem.OOMKills()
+	if !ok {
+		return -1
+	}
+	return n
+}
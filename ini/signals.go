@@ -0,0 +1,45 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/streamz/drinit/sig"
+)
+
+// signals - the signals drinit received, oldest first
+func (i *Init) signals() string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSIGNAL\tACTION\tPID\tRESULT")
+	for _, e := range i.sig.Events() {
+		pid := "-"
+		if e.Pid > 0 {
+			pid = fmt.Sprintf("%d", e.Pid)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Format("2006-01-02T15:04:05.000"), sig.SignalToName(e.Signal),
+			e.Action, pid, dash(e.Result))
+	}
+	w.Flush()
+	return sb.String()
+}
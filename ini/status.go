@@ -0,0 +1,136 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/streamz/drinit/cgroup"
+	"github.com/streamz/drinit/exe"
+	"github.com/streamz/drinit/proc"
+)
+
+// status - a report of the supervised program for drinitctl status
+func (i *Init) status() string {
+	i.lok.RLock()
+	inf := i.exc.Info()
+	i.lok.RUnlock()
+
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "program:\t%s\n", strings.Join(i.cmd, " "))
+	fmt.Fprintf(w, "state:\t%s\n", state(inf))
+	fmt.Fprintf(w, "pid:\t%d\n", inf.Pid)
+	fmt.Fprintf(w, "started:\t%s\n", stamp(inf.StartT))
+	if len(inf.Cgroup) > 0 {
+		fmt.Fprintf(w, "cgroup:\t%s\n", inf.Cgroup)
+	}
+
+	if inf.EndT == 0 {
+		if inf.StartT != 0 {
+			fmt.Fprintf(w, "runtime:\t%v\n", inf.RunT.Round(time.Millisecond))
+		}
+		if len(inf.Cgroup) > 0 {
+			cgusage(w, cgroup.Open(inf.Cgroup).Stats())
+		}
+	} else {
+		fmt.Fprintf(w, "ended:\t%s\n", stamp(inf.EndT))
+		fmt.Fprintf(w, "runtime:\t%v\n", time.Duration(inf.EndT-inf.StartT).Round(time.Millisecond))
+		fmt.Fprintf(w, "status:\t%d\n", inf.Status)
+		fmt.Fprintf(w, "signal:\t%s\n", dash(signame(inf.Signal)))
+		fmt.Fprintf(w, "core:\t%v\n", inf.Core)
+		fmt.Fprintf(w, "utime:\t%v\n", inf.Utime)
+		fmt.Fprintf(w, "stime:\t%v\n", inf.Stime)
+		fmt.Fprintf(w, "maxrss:\t%dK\n", inf.MaxRSS)
+		if inf.Usage != nil {
+			cgusage(w, *inf.Usage)
+		}
+		if inf.Error != nil {
+			fmt.Fprintf(w, "error:\t%s\n", inf.Error.Error())
+		}
+	}
+	fmt.Fprintf(w, "runs:\t%d\n", len(i.hst.list()))
+	if i.rso {
+		fmt.Fprintf(w, "restarts:\t%d\n", i.rsc.Get())
+	}
+	if n := i.oom.Get(); n > 0 {
+		fmt.Fprintf(w, "oom kills:\t%d\n", n)
+	}
+
+	rs := i.rpr.Stats()
+	fmt.Fprintf(w, "reaped:\t%d\n", rs.Reaped)
+	fmt.Fprintf(w, "orphans:\t%d, %d in the last minute\n", rs.Orphans, rs.LastMinute)
+	fmt.Fprintf(w, "zombies:\t%d\n", zombies())
+	w.Flush()
+
+	if len(rs.Recent) > 0 {
+		sb.WriteString("\nrecent orphans:\n")
+		w = tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PID\tREAPED\tSTATUS\tSIGNAL\tUTIME\tSTIME\tMAXRSS\tCOMMAND")
+		for _, o := range rs.Recent {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%v\t%v\t%dK\t%s\n",
+				o.Pid, stamp(o.ReapT), o.Status, dash(signame(o.Signal)),
+				o.Utime, o.Stime, o.MaxRSS, o.Comm)
+		}
+		w.Flush()
+	}
+	return sb.String()
+}
+
+// cgusage - the accounting of the program's cgroup, memory and pids are
+// only known when their controllers are enabled
+func cgusage(w io.Writer, st cgroup.Stats) {
+	fmt.Fprintf(w, "cgroup cpu:\t%v (user %v, system %v)\n", st.CPU, st.User, st.System)
+	fmt.Fprintf(w, "memory:\t%s, peak %s\n", kbytes(st.Memory), kbytes(st.MemoryPeak))
+	if st.Pids > 0 {
+		fmt.Fprintf(w, "pids:\t%d\n", st.Pids)
+	}
+}
+
+// zombies - counts the exited children of drinit that are not yet reaped
+func zombies() int {
+	n := 0
+	if kids, e := proc.Descendants(os.Getpid()); e == nil {
+		for _, k := range kids {
+			if k.State == "Z" {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func state(inf exe.Info) string {
+	switch {
+	case inf.StartT == 0:
+		return "not started"
+	case inf.EndT == 0 && inf.Stopped.Get():
+		return "suspended"
+	case inf.EndT == 0:
+		return "running"
+	case inf.Signaled.Get():
+		return "stopped"
+	}
+	return "exited"
+}
@@ -0,0 +1,150 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/streamz/drinit/proc"
+	"github.com/streamz/drinit/sig"
+)
+
+// DefaultTeardown - the default time remaining processes are given to exit
+// on shutdown before they are killed
+const DefaultTeardown = 5 * time.Second
+
+// teardown - stops every process still running under drinit, daemons that
+// called setsid and leftover trap script children included, with the
+// remapped SIGTERM, anything alive
+// after the teardown budget is killed, returns the processes it killed,
+// with cgroups the processes in the sub-groups are stopped as well, even
+// those that left drinit's process tree, and killed with cgroup.kill
+func (i *Init) teardown() []*proc.Stat {
+	defer i.clean()
+	procs := i.remaining()
+	if len(procs) == 0 {
+		return nil
+	}
+
+	// the same stop signal the program is sent, --remap included
+	stop := i.remap(syscall.SIGTERM).(syscall.Signal)
+	i.log.Infof("stopping %d remaining processes with %s", len(procs), sig.SignalToName(stop))
+	for _, p := range procs {
+		if e := syscall.Kill(p.Pid, stop); e != nil && e != syscall.ESRCH {
+			i.log.Errorf("teardown: pid %d, %s", p.Pid, e.Error())
+		}
+	}
+
+	deadline := time.Now().Add(i.tdn)
+	for time.Now().Before(deadline) {
+		if len(i.remaining()) == 0 {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var killed []*proc.Stat
+	if i.cgt != nil {
+		// a group is killed at once, its processes cannot fork away
+		for _, p := range i.grouped() {
+			i.log.Warnf("teardown: killing pid %d (ppid %d), %s", p.Pid, p.Ppid, proc.Cmdline(p.Pid))
+			killed = append(killed, p)
+		}
+		i.cgt.Kill(time.Second)
+	}
+
+	// walk the tree again on every pass, survivors may have forked
+	for pass := 0; pass < 3; pass++ {
+		procs = living()
+		if len(procs) == 0 {
+			break
+		}
+		for _, p := range procs {
+			cmd := proc.Cmdline(p.Pid)
+			if e := syscall.Kill(p.Pid, syscall.SIGKILL); e != nil {
+				continue
+			}
+			i.log.Warnf("teardown: killed pid %d (ppid %d), %s", p.Pid, p.Ppid, cmd)
+			killed = append(killed, p)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	i.log.Warnf("teardown: killed %d processes still running after %v", len(killed), i.tdn)
+	return killed
+}
+
+// remaining - drinit's descendants and the processes in its cgroups that
+// have not exited
+func (i *Init) remaining() []*proc.Stat {
+	procs := living()
+	seen := make(map[int]bool, len(procs))
+	for _, p := range procs {
+		seen[p.Pid] = true
+	}
+	for _, p := range i.grouped() {
+		if !seen[p.Pid] {
+			procs = append(procs, p)
+		}
+	}
+	return procs
+}
+
+// grouped - the processes in drinit's cgroups that have not exited
+func (i *Init) grouped() []*proc.Stat {
+	if i.cgt == nil {
+		return nil
+	}
+	var procs []*proc.Stat
+	for _, pid := range i.cgt.Procs() {
+		if st, e := proc.ReadStat(pid); e == nil && st.State != "Z" {
+			procs = append(procs, st)
+		}
+	}
+	return procs
+}
+
+// clean - removes the cgroups left behind by runs whose descendants kept
+// running after they exited
+func (i *Init) clean() {
+	if i.cgt != nil {
+		i.cgt.Clean(0)
+	}
+}
+
+// living - drinit's descendants that have not exited
+func living() []*proc.Stat {
+	kids, e := proc.Descendants(os.Getpid())
+	if e != nil {
+		return nil
+	}
+	return alive(kids)
+}
+
+// alive - filters out processes that have exited, zombies included
+func alive(procs []*proc.Stat) []*proc.Stat {
+	var res []*proc.Stat
+	for _, p := range procs {
+		if st, e := proc.ReadStat(p.Pid); e == nil && st.State != "Z" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
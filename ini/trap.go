@@ -0,0 +1,91 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ini
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/streamz/drinit/exe"
+	"github.com/streamz/drinit/sig"
+)
+
+// outcomes - the exit codes of a trap in sig.ModeExitCode, any other exit
+// code is logged and the signal is swallowed
+const (
+	// OutcomeSwallow - drop the signal
+	OutcomeSwallow = 0
+	// OutcomeForward - forward the signal to the program
+	OutcomeForward = 10
+	// OutcomeCycle - restart the program
+	OutcomeCycle = 11
+	// OutcomeStop - stop the program
+	OutcomeStop = 12
+	// OutcomeExit - shut drinit down, exiting the container
+	OutcomeExit = 13
+)
+
+// outcome - acts on the exit code of a trap run for signal
+func (i *Init) outcome(signal os.Signal, err error) error {
+	code := OutcomeSwallow
+	if err != nil {
+		x, ok := err.(*exe.ExitError)
+		if !ok || !x.Exited() {
+			return err
+		}
+		code = x.ExitStatus()
+	}
+
+	i.log.Tracef("trap %s exited with %d", sig.SignalToName(signal), code)
+	switch code {
+	case OutcomeSwallow:
+		return nil
+	case OutcomeForward:
+		i.sig.Forward(signal)
+		return nil
+	case OutcomeCycle, OutcomeStop, OutcomeExit:
+		// stopping the program can take as long as it does, the service
+		// loop acts on the outcome so the trap worker is not held up
+		select {
+		case i.otc <- code:
+		case <-i.ctx.Done():
+		}
+		return nil
+	}
+	return fmt.Errorf("trap %s: unknown outcome, %s", sig.SignalToName(signal), err.Error())
+}
+
+// act - runs a trap outcome handed to the service loop
+func (i *Init) act(code int) {
+	var e error
+	switch code {
+	case OutcomeCycle:
+		i.cause(TriggerTrap)
+		e = restart(i)
+	case OutcomeStop:
+		i.cause(TriggerTrap)
+		e = stop(i)
+	case OutcomeExit:
+		// the service loop shuts drinit down once the context is done
+		i.can()
+	}
+	if e != nil {
+		i.log.Error(e.Error())
+	}
+}
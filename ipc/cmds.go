@@ -30,4 +30,22 @@ const (
 
 	// Cycle -
 	Cycle = "cycle"
+
+	// History - query the run history of the supervised program
+	History = "history"
+
+	// Status - query the state of the supervised program
+	Status = "status"
+
+	// Metrics - query drinit's counters in the prometheus text format
+	Metrics = "metrics"
+
+	// Ps - query the process tree under drinit
+	Ps = "ps"
+
+	// Signals - query the signals drinit received
+	Signals = "signals"
+
+	// Stdin - write a line to the stdin of the supervised program
+	Stdin = "stdin"
 )
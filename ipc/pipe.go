@@ -37,6 +37,9 @@ type Msg struct {
 	epoc int64
 	Name string
 	Args []string
+	// Reply - the pipe a response is written to, empty when the sender
+	// does not wait for one
+	Reply string
 }
 
 // Epoch -
@@ -45,6 +48,9 @@ func (m *Msg) Epoch() int64 {
 }
 
 func (m *Msg) String() string {
+	if len(m.Reply) > 0 {
+		return fmt.Sprintf("%d >%s %s %s", m.epoc, m.Reply, m.Name, strings.Join(m.Args, " "))
+	}
 	return fmt.Sprintf("%d %s %s", m.epoc, m.Name, strings.Join(m.Args, " "))
 }
 
@@ -54,6 +60,7 @@ type Pipe struct {
 	once sync.Once
 	clsd util.AtomicBool
 	mchn chan Msg
+	done chan struct{}
 }
 
 var _log = log.Logger()
@@ -74,6 +81,7 @@ func New(desc string) (*Pipe, error) {
 		once: sync.Once{},
 		clsd: util.AtomicBool{},
 		mchn: make(chan Msg, 1),
+		done: make(chan struct{}),
 	}, nil
 }
 
@@ -81,6 +89,8 @@ func New(desc string) (*Pipe, error) {
 func (p *Pipe) Open() <-chan Msg {
 	p.once.Do(func() {
 		go func(p *Pipe) {
+			// the reader owns mchn, Close only tells it to stop
+			defer close(p.mchn)
 			reader := bufio.NewReader(p.file)
 			for {
 				// will block when empty
@@ -97,12 +107,22 @@ func (p *Pipe) Open() <-chan Msg {
 				if err != nil {
 					epoc = time.Now().Unix()
 				}
+				reply := ""
+				if strings.HasPrefix(sa[1], ">") {
+					reply = sa[1][1:]
+					sa = sa[1:]
+				}
 				msg := Msg{
-					epoc: epoc,
-					Name: sa[1],
-					Args: sa[2:],
+					epoc:  epoc,
+					Name:  sa[1],
+					Args:  sa[2:],
+					Reply: reply,
+				}
+				select {
+				case p.mchn <- msg:
+				case <-p.done:
+					return
 				}
-				p.mchn <- msg
 			}
 		}(p)
 	})
@@ -113,7 +133,7 @@ func (p *Pipe) Open() <-chan Msg {
 func (p *Pipe) Close() {
 	defer os.RemoveAll(p.file.Name())
 	p.clsd.Set()
-	close(p.mchn)
+	close(p.done)
 	p.file.Close()
 }
 
@@ -134,3 +154,66 @@ func Send(desc string, msg Msg) error {
 	}
 	return nil
 }
+
+// eot - ends a response, the requester holds the reply pipe open for
+// writing too so it never reads an end of file
+const eot = '\x04'
+
+// seq - numbers the reply pipes of a process
+var seq util.AtomicInt32
+
+// Request - sends a message to the desc (file) and waits for the response,
+// the response is read from a temporary reply pipe named in the message, a
+// timeout of 0 waits for as long as the response takes
+func Request(desc string, msg Msg, timeout time.Duration) (string, error) {
+	reply := fmt.Sprintf("%s.%d.%d.reply", desc, os.Getpid(), seq.Incr())
+	os.RemoveAll(reply)
+	if e := syscall.Mkfifo(reply, 0600); e != nil {
+		return "", e
+	}
+	defer os.RemoveAll(reply)
+
+	// read write so the open does not wait for the responder, and the
+	// responder finds a reader as soon as the message is sent
+	r, e := os.OpenFile(reply, os.O_RDWR, os.ModeNamedPipe)
+	if e != nil {
+		return "", e
+	}
+	defer r.Close()
+
+	msg.Reply = reply
+	if e = Send(desc, msg); e != nil {
+		return "", e
+	}
+
+	if timeout > 0 {
+		r.SetReadDeadline(time.Now().Add(timeout))
+	}
+	res, e := bufio.NewReader(r).ReadString(eot)
+	if os.IsTimeout(e) {
+		return "", fmt.Errorf("no response to %s within %v", msg.Name, timeout)
+	}
+	if e != nil {
+		return "", e
+	}
+	return strings.TrimSuffix(res, string(eot)), nil
+}
+
+// Respond - writes a response to the reply pipe of a message,
+// messages without a reply pipe are ignored
+func Respond(msg Msg, response string) error {
+	if len(msg.Reply) == 0 {
+		return nil
+	}
+
+	// never block on a requester that has gone away, opening a pipe
+	// without a reader fails with ENXIO
+	w, e := os.OpenFile(msg.Reply, os.O_WRONLY|syscall.O_NONBLOCK, os.ModeNamedPipe)
+	if e != nil {
+		return e
+	}
+	defer w.Close()
+
+	_, e = w.WriteString(response + string(eot))
+	return e
+}
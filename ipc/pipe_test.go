@@ -19,7 +19,12 @@ limitations under the License.
 package ipc
 
 import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -80,3 +85,75 @@ func TestLoopPipe(t *testing.T) {
 		}
 	}
 }
+
+func TestRequest(t *testing.T) {
+	fd := "/tmp/test-request.pipe"
+	pipe, err := New(fd)
+	assert.NoError(t, err)
+	defer pipe.Close()
+
+	recv := pipe.Open()
+
+	go func() {
+		msg := <-recv
+		assert.Equal(t, expect[0].Name, msg.Name, "message Name should be equal")
+		assert.Equal(t, expect[0].Args, msg.Args, "message Args should be equal")
+		Respond(msg, "response\n")
+	}()
+
+	res, err := Request(fd, expect[0], time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "response\n", res, "response should be equal")
+}
+
+func TestRequestTimeout(t *testing.T) {
+	fd := "/tmp/test-request-timeout.pipe"
+	pipe, err := New(fd)
+	assert.NoError(t, err)
+	defer pipe.Close()
+
+	pipe.Open()
+
+	_, err = Request(fd, expect[0], 100*time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	fd := "/tmp/test-concurrent.pipe"
+	pipe, err := New(fd)
+	assert.NoError(t, err)
+	defer pipe.Close()
+
+	recv := pipe.Open()
+	go func() {
+		for msg := range recv {
+			Respond(msg, msg.Args[0])
+		}
+	}()
+
+	// each request of a process has its own reply pipe
+	w := &sync.WaitGroup{}
+	for n := 0; n < 8; n++ {
+		w.Add(1)
+		go func(n int) {
+			defer w.Done()
+			arg := fmt.Sprintf("%d", n)
+			res, e := Request(fd, Msg{Name: "echo", Args: []string{arg}}, 5*time.Second)
+			assert.NoError(t, e)
+			assert.Equal(t, arg, res)
+		}(n)
+	}
+	w.Wait()
+}
+
+func TestRespondGone(t *testing.T) {
+	reply := "/tmp/test-respond-gone.reply"
+	os.RemoveAll(reply)
+	assert.NoError(t, syscall.Mkfifo(reply, 0600))
+	defer os.RemoveAll(reply)
+
+	// nobody reads the reply pipe, the responder does not wait
+	start := time.Now()
+	assert.Error(t, Respond(Msg{Name: "gone", Reply: reply}, "response\n"))
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "should not wait for the requester")
+}
@@ -0,0 +1,191 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Root - the procfs mount point
+const Root = "/proc"
+
+// Stat - the fields drinit uses from /proc/<pid>/stat
+type Stat struct {
+	Pid, Ppid, Pgid, Sid int
+	State                string
+	Comm                 string
+	// Utime, Stime - cpu time in clock ticks
+	Utime, Stime uint64
+	// Rss - resident set size in pages
+	Rss int64
+}
+
+// Path - returns the path of a file under /proc/<pid>
+func Path(pid int, name string) string {
+	return filepath.Join(Root, strconv.Itoa(pid), name)
+}
+
+// ReadStat - reads and parses /proc/<pid>/stat
+func ReadStat(pid int) (*Stat, error) {
+	b, e := ioutil.ReadFile(Path(pid, "stat"))
+	if e != nil {
+		return nil, e
+	}
+	return parsestat(string(b))
+}
+
+// List - returns the stat of every process visible in /proc
+func List() ([]*Stat, error) {
+	d, e := os.Open(Root)
+	if e != nil {
+		return nil, e
+	}
+	defer d.Close()
+
+	names, e := d.Readdirnames(-1)
+	if e != nil {
+		return nil, e
+	}
+
+	var stats []*Stat
+	for _, n := range names {
+		pid, e := strconv.Atoi(n)
+		if e != nil {
+			continue
+		}
+		// processes can exit while we walk the directory
+		if st, e := ReadStat(pid); e == nil {
+			stats = append(stats, st)
+		}
+	}
+	return stats, nil
+}
+
+// Tree - returns the process root and all of its descendants, parents
+// are always listed before their children
+func Tree(root int) ([]*Stat, error) {
+	stats, e := List()
+	if e != nil {
+		return nil, e
+	}
+
+	children := make(map[int][]*Stat)
+	var top *Stat
+	for _, st := range stats {
+		children[st.Ppid] = append(children[st.Ppid], st)
+		if st.Pid == root {
+			top = st
+		}
+	}
+	if top == nil {
+		return nil, fmt.Errorf("proc: no such process %d", root)
+	}
+
+	tree := []*Stat{top}
+	for n := 0; n < len(tree); n++ {
+		tree = append(tree, children[tree[n].Pid]...)
+	}
+	return tree, nil
+}
+
+// Descendants - returns all of root's descendants, excluding root
+func Descendants(root int) ([]*Stat, error) {
+	tree, e := Tree(root)
+	if e != nil {
+		return nil, e
+	}
+	return tree[1:], nil
+}
+
+// Cmdline - returns the command line of a process, zombies and kernel
+// threads have none, so the command name is returned in brackets
+func Cmdline(pid int) string {
+	b, e := ioutil.ReadFile(Path(pid, "cmdline"))
+	if e == nil && len(b) > 0 {
+		return strings.TrimSpace(strings.ReplaceAll(string(b), "\x00", " "))
+	}
+	if comm := Comm(pid); len(comm) > 0 {
+		return "[" + comm + "]"
+	}
+	return ""
+}
+
+// Comm - returns the command name of a process, truncated by the kernel
+// to 15 bytes, unlike the command line it is kept for zombies
+func Comm(pid int) string {
+	b, e := ioutil.ReadFile(Path(pid, "comm"))
+	if e != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func parsestat(s string) (*Stat, error) {
+	// comm is in parens and may itself contain spaces and parens
+	l := strings.IndexByte(s, '(')
+	r := strings.LastIndexByte(s, ')')
+	if l < 0 || r < l {
+		return nil, fmt.Errorf("proc: malformed stat %q", s)
+	}
+
+	pid, e := strconv.Atoi(strings.TrimSpace(s[:l]))
+	if e != nil {
+		return nil, e
+	}
+
+	// fields after comm start at field 3 (state)
+	f := strings.Fields(s[r+1:])
+	if len(f) < 22 {
+		return nil, fmt.Errorf("proc: short stat for pid %d", pid)
+	}
+
+	st := &Stat{
+		Pid:   pid,
+		Comm:  s[l+1 : r],
+		State: f[0],
+	}
+	st.Ppid, _ = strconv.Atoi(f[1])
+	st.Pgid, _ = strconv.Atoi(f[2])
+	st.Sid, _ = strconv.Atoi(f[3])
+	st.Utime, _ = strconv.ParseUint(f[11], 10, 64)
+	st.Stime, _ = strconv.ParseUint(f[12], 10, 64)
+	st.Rss, _ = strconv.ParseInt(f[21], 10, 64)
+	return st, nil
+}
+
+// Uid - returns the real user id of a process from /proc/<pid>/status
+func Uid(pid int) (int, error) {
+	b, e := ioutil.ReadFile(Path(pid, "status"))
+	if e != nil {
+		return -1, e
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(l, "Uid:") {
+			if f := strings.Fields(l); len(f) > 1 {
+				return strconv.Atoi(f[1])
+			}
+		}
+	}
+	return -1, fmt.Errorf("proc: no uid for pid %d", pid)
+}
@@ -0,0 +1,71 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStat(t *testing.T) {
+	s := "42 (a (weird) name) S 1 42 42 0 -1 4194560 1 0 0 0 7 3 0 0 20 0 1 0 100 1000 25 18446744073709551615"
+	st, e := parsestat(s)
+	assert.NoError(t, e)
+	assert.Equal(t, 42, st.Pid)
+	assert.Equal(t, "a (weird) name", st.Comm)
+	assert.Equal(t, "S", st.State)
+	assert.Equal(t, 1, st.Ppid)
+	assert.Equal(t, 42, st.Pgid)
+	assert.Equal(t, uint64(7), st.Utime)
+	assert.Equal(t, uint64(3), st.Stime)
+	assert.Equal(t, int64(25), st.Rss)
+
+	_, e = parsestat("garbage")
+	assert.Error(t, e)
+}
+
+func TestTree(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	tree, e := Tree(os.Getpid())
+	assert.NoError(t, e)
+	assert.Equal(t, os.Getpid(), tree[0].Pid, "root should be first")
+
+	found := false
+	for _, st := range tree[1:] {
+		if st.Pid == cmd.Process.Pid {
+			found = true
+			assert.Equal(t, os.Getpid(), st.Ppid)
+		}
+	}
+	assert.True(t, found, "child should be a descendant")
+	assert.Contains(t, Cmdline(cmd.Process.Pid), "sleep 10")
+	assert.Equal(t, "sleep", Comm(cmd.Process.Pid))
+
+	_, e = Tree(-1)
+	assert.Error(t, e)
+}
@@ -0,0 +1,127 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sig
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultEvents - the default number of signal events kept
+const DefaultEvents = 64
+
+// actions - what the handler did with a signal
+const (
+	// ActionForward - forwarded to the program
+	ActionForward = "forward"
+	// ActionTrap - the trap ran
+	ActionTrap = "trap"
+	// ActionCoalesce - merged into a pending trap
+	ActionCoalesce = "coalesce"
+	// ActionDrop - the trap queue was full
+	ActionDrop = "drop"
+	// ActionSkip - the trap was running
+	ActionSkip = "skip"
+)
+
+// results of an action that did not fail
+const (
+	ResultOK       = "ok"
+	ResultTimeout  = "timed out"
+	ResultRestart  = "restarted"
+	ResultCanceled = "canceled"
+)
+
+// Event - a signal received by drinit and what was done with it
+type Event struct {
+	// Time - when the action completed
+	Time   time.Time
+	Signal os.Signal
+	Action string
+	// Pid - the program's pid at the time, 0 if it was not known
+	Pid int
+	// Result - ok, or the error returned by the trap or forward handler
+	Result string
+}
+
+// events - a ring buffer of the most recent signal events
+type events struct {
+	lok sync.Mutex
+	buf []Event
+	nxt int
+	ful bool
+}
+
+func newevents(max int) *events {
+	if max <= 0 {
+		max = DefaultEvents
+	}
+	return &events{buf: make([]Event, max)}
+}
+
+func (ev *events) add(e Event) {
+	ev.lok.Lock()
+	defer ev.lok.Unlock()
+
+	ev.buf[ev.nxt] = e
+	ev.nxt = (ev.nxt + 1) % len(ev.buf)
+	if ev.nxt == 0 {
+		ev.ful = true
+	}
+}
+
+// list - the events, oldest first
+func (ev *events) list() []Event {
+	ev.lok.Lock()
+	defer ev.lok.Unlock()
+
+	if !ev.ful {
+		return append([]Event(nil), ev.buf[:ev.nxt]...)
+	}
+	return append(append([]Event(nil), ev.buf[ev.nxt:]...), ev.buf[:ev.nxt]...)
+}
+
+// record - adds an event for sig, err is the result of the action
+func (h *Signalh) record(sig os.Signal, action, result string, err error) {
+	// SIGCHLD is the reaper's and would flush every other event
+	if sig == syscall.SIGCHLD {
+		return
+	}
+	if err != nil {
+		result = err.Error()
+	}
+	pid := 0
+	if h.pidf != nil {
+		pid = h.pidf()
+	}
+	h.evts.add(Event{
+		Time:   time.Now(),
+		Signal: sig,
+		Action: action,
+		Pid:    pid,
+		Result: result,
+	})
+}
+
+// Events - the most recent signals received, oldest first
+func (h *Signalh) Events() []Event {
+	return h.evts.list()
+}
@@ -0,0 +1,239 @@
+// +build linux
+
+/*
+Copyright © 2020 streamz <bytecodenerd@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sig
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultDepth - the default number of deliveries queued for RuleQueue
+const DefaultDepth = 16
+
+// Rule - what happens to a trapped signal delivered while its trap is
+// pending or running
+type Rule int
+
+const (
+	// RuleCoalesce - deliveries received while one is pending are merged
+	// into it, the trap runs at most once more after the running one
+	RuleCoalesce Rule = iota
+	// RuleQueue - every delivery runs the trap, deliveries beyond the
+	// queue depth are dropped
+	RuleQueue
+	// RuleSkip - deliveries received while the trap runs are skipped
+	RuleSkip
+	// RuleRestart - a delivery received while the trap runs cancels it,
+	// and the trap is run again
+	RuleRestart
+)
+
+var rule2name = map[Rule]string{
+	RuleCoalesce: "coalesce",
+	RuleQueue:    "queue",
+	RuleSkip:     "skip",
+	RuleRestart:  "restart",
+}
+
+func (r Rule) String() string {
+	if name, ok := rule2name[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("rule(%d)", int(r))
+}
+
+// ToRule - string to Rule
+func ToRule(name string) (Rule, error) {
+	for r, n := range rule2name {
+		if n == name {
+			return r, nil
+		}
+	}
+	return RuleCoalesce, fmt.Errorf("invalid queue rule: %s", name)
+}
+
+// Counters - what happened to the deliveries of a signal
+type Counters struct {
+	// Received - every delivery, Forwarded - forwarded to the program,
+	// Trapped - trap runs
+	Received, Forwarded, Trapped int
+	// Coalesced - merged into a pending delivery, Dropped - the queue
+	// was full, Skipped - the trap was running
+	Coalesced, Dropped, Skipped int
+	// Restarted - trap runs canceled by a later delivery, TimedOut -
+	// trap runs canceled by their timeout
+	Restarted, TimedOut int
+}
+
+// queue - the pending deliveries of a trapped signal, each trapped signal
+// has its own worker so a slow trap only delays its own signal
+type queue struct {
+	mode Mode
+	rule Rule
+	tout time.Duration
+	pend chan os.Signal
+	lok  sync.Mutex
+	// can - cancels the running trap, nil when the trap is not running
+	can context.CancelFunc
+	rst bool
+}
+
+func newqueue(mode Mode, rule Rule, tout time.Duration, depth int) *queue {
+	size := 1
+	if rule == RuleQueue {
+		size = depth
+	}
+	return &queue{
+		mode: mode,
+		rule: rule,
+		tout: tout,
+		pend: make(chan os.Signal, size),
+	}
+}
+
+// push - queues a delivery without blocking the receive loop
+func (h *Signalh) push(q *queue, sig os.Signal) {
+	q.lok.Lock()
+	running := q.can != nil
+	switch {
+	case running && q.rule == RuleSkip:
+		q.lok.Unlock()
+		h.count(sig, func(c *Counters) { c.Skipped++ })
+		h.record(sig, ActionSkip, "", nil)
+		h.logr.Infof("trap for %s is running, skipped signal", SignalToName(sig))
+		return
+	case running && q.rule == RuleRestart && !q.rst:
+		q.rst = true
+		q.can()
+		h.count(sig, func(c *Counters) { c.Restarted++ })
+	}
+	q.lok.Unlock()
+
+	select {
+	case q.pend <- sig:
+		return
+	default:
+	}
+
+	if q.rule == RuleQueue {
+		h.count(sig, func(c *Counters) { c.Dropped++ })
+		h.record(sig, ActionDrop, "", nil)
+		h.logr.Warnf("trap queue for %s is full, dropped signal", SignalToName(sig))
+		return
+	}
+	h.count(sig, func(c *Counters) { c.Coalesced++ })
+	h.record(sig, ActionCoalesce, "", nil)
+	h.logr.Tracef("coalesced signal %s", SignalToName(sig))
+}
+
+// work - runs the trap for each queued delivery of a signal
+func (h *Signalh) work(q *queue) {
+	for {
+		select {
+		case sig := <-q.pend:
+			if h.trap(q, sig) && q.mode == ModeRunForward {
+				h.Forward(sig)
+			}
+		case <-h.done.Done():
+			return
+		}
+	}
+}
+
+// trap - runs the trap with the queue's timeout and logs its outcome,
+// returns false if the run was canceled
+func (h *Signalh) trap(q *queue, sig os.Signal) bool {
+	ctx, can := context.WithCancel(h.done)
+	if q.tout > 0 {
+		ctx, can = context.WithTimeout(h.done, q.tout)
+	}
+	defer can()
+
+	q.lok.Lock()
+	q.can, q.rst = can, false
+	q.lok.Unlock()
+
+	h.count(sig, func(c *Counters) { c.Trapped++ })
+	start := time.Now()
+	e := h.sigf(ctx, sig)
+	took := time.Since(start)
+
+	q.lok.Lock()
+	rst := q.rst
+	q.can, q.rst = nil, false
+	q.lok.Unlock()
+
+	switch {
+	case rst:
+		h.record(sig, ActionTrap, ResultRestart, nil)
+		h.logr.Infof("trap for %s canceled after %s, restarting", SignalToName(sig), took)
+		return false
+	case ctx.Err() == context.DeadlineExceeded:
+		h.count(sig, func(c *Counters) { c.TimedOut++ })
+		h.record(sig, ActionTrap, ResultTimeout, nil)
+		h.logr.Warnf("trap for %s timed out after %s and was killed", SignalToName(sig), q.tout)
+	case ctx.Err() == context.Canceled:
+		h.record(sig, ActionTrap, ResultCanceled, nil)
+	case e != nil:
+		h.record(sig, ActionTrap, "", e)
+		h.logr.Errorf("trap for %s failed after %s, %s", SignalToName(sig), took, e.Error())
+	default:
+		h.record(sig, ActionTrap, ResultOK, nil)
+		h.logr.Infof("trap for %s completed in %s", SignalToName(sig), took)
+	}
+	return ctx.Err() != context.Canceled
+}
+
+// Forward - forwards sig to the program, counted and recorded as every
+// other forward so a trap can hand a signal on after handling it
+func (h *Signalh) Forward(sig os.Signal) {
+	h.count(sig, func(c *Counters) { c.Forwarded++ })
+	e := h.fwdf(sig)
+	h.record(sig, ActionForward, ResultOK, e)
+	if e != nil {
+		h.logr.Error(e.Error())
+	}
+}
+
+func (h *Signalh) count(sig os.Signal, f func(*Counters)) {
+	h.lok.Lock()
+	defer h.lok.Unlock()
+
+	c, ok := h.cnts[sig]
+	if !ok {
+		c = &Counters{}
+		h.cnts[sig] = c
+	}
+	f(c)
+}
+
+// Stats - the delivery counters of every signal received
+func (h *Signalh) Stats() map[os.Signal]Counters {
+	h.lok.Lock()
+	defer h.lok.Unlock()
+
+	res := make(map[os.Signal]Counters, len(h.cnts))
+	for s, c := range h.cnts {
+		res[s] = *c
+	}
+	return res
+}
@@ -23,8 +23,11 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/streamz/drinit/log"
 	"github.com/streamz/drinit/util"
@@ -33,11 +36,74 @@ import (
 // Signalf - type for a signal handler
 type Signalf = func(os.Signal) error
 
+// Signalc - type for a trap handler that stops when ctx is done, on a
+// timeout or when a later delivery restarts it
+type Signalc = func(context.Context, os.Signal) error
+
+// Mode - what the handler does with a trapped signal
+type Mode int
+
+const (
+	// ModeTrap - run the trap handler, the signal is not forwarded
+	ModeTrap Mode = iota
+	// ModeRunForward - run the trap handler, then forward the signal
+	ModeRunForward
+	// ModeForwardRun - forward the signal, then run the trap handler
+	ModeForwardRun
+	// ModeExitCode - run the trap handler and let its result decide what
+	// happens next, the signal is not forwarded by the handler
+	ModeExitCode
+)
+
+var mode2name = map[Mode]string{
+	ModeTrap:       "trap",
+	ModeRunForward: "run-forward",
+	ModeForwardRun: "forward-run",
+	ModeExitCode:   "exit-code",
+}
+
+func (m Mode) String() string {
+	if name, ok := mode2name[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("mode(%d)", int(m))
+}
+
+// ToMode - string to Mode
+func ToMode(name string) (Mode, error) {
+	for m, n := range mode2name {
+		if n == name {
+			return m, nil
+		}
+	}
+	return ModeTrap, fmt.Errorf("invalid trap mode: %s", name)
+}
+
 // SignalOpts -
 type SignalOpts struct {
 	Trapf Signalf
+	// Trapc - a trap handler that can be canceled, used instead of Trapf
+	// when set
+	Trapc Signalc
 	Fwrdf Signalf
 	Traps []os.Signal
+	// Modes - the mode of each trap, traps without one use ModeTrap
+	Modes map[os.Signal]Mode
+	// Rules - the queue rule of each trap, traps without one use
+	// RuleCoalesce
+	Rules map[os.Signal]Rule
+	// Depth - the queue depth for RuleQueue, 0 uses DefaultDepth
+	Depth int
+	// Timeouts - how long each trap may run before it is canceled, traps
+	// without one are not timed out
+	Timeouts map[os.Signal]time.Duration
+	// Ignore - signals that are neither trapped nor forwarded, ie. SIGTTOU
+	// so drinit can take back the terminal from the background
+	Ignore []os.Signal
+	// Events - the number of signal events kept, 0 uses DefaultEvents
+	Events int
+	// Pidf - returns the program's pid, recorded with each event
+	Pidf func() int
 }
 
 // Signalh - internal signal handle state
@@ -45,8 +111,13 @@ type Signalh struct {
 	logr *log.Log
 	strt sync.Once
 	init util.AtomicBool
-	sigs map[os.Signal]struct{}
-	sigf Signalf
+	sigs map[os.Signal]*queue
+	lok  sync.Mutex
+	cnts map[os.Signal]*Counters
+	ignr []os.Signal
+	evts *events
+	pidf func() int
+	sigf Signalc
 	fwdf Signalf
 	done context.Context
 	Stop context.CancelFunc
@@ -56,7 +127,7 @@ type Signalh struct {
 func New(opts SignalOpts) *Signalh {
 	noop := func(os.Signal) error { return nil }
 	traps := opts.Traps
-	trapf := opts.Trapf
+	trapf := opts.Trapc
 	fwrdf := opts.Fwrdf
 	logr := log.Logger()
 
@@ -64,8 +135,15 @@ func New(opts SignalOpts) *Signalh {
 		traps = []os.Signal{}
 	}
 
+	if trapf == nil && opts.Trapf != nil {
+		f := opts.Trapf
+		trapf = func(_ context.Context, sig os.Signal) error {
+			return f(sig)
+		}
+	}
+
 	if trapf == nil {
-		trapf = noop
+		trapf = func(context.Context, os.Signal) error { return nil }
 		if len(traps) > 0 {
 			logr.Warn("signal handler is nil, ignoring traps")
 			traps = []os.Signal{}
@@ -77,9 +155,14 @@ func New(opts SignalOpts) *Signalh {
 		fwrdf = noop
 	}
 
-	signals := make(map[os.Signal]struct{})
+	depth := opts.Depth
+	if depth <= 0 {
+		depth = DefaultDepth
+	}
+
+	signals := make(map[os.Signal]*queue)
 	for _, s := range traps {
-		signals[s] = struct{}{}
+		signals[s] = newqueue(opts.Modes[s], opts.Rules[s], opts.Timeouts[s], depth)
 	}
 
 	ctx, stop := context.WithCancel(context.Background())
@@ -88,6 +171,10 @@ func New(opts SignalOpts) *Signalh {
 		strt: sync.Once{},
 		init: util.AtomicBool{},
 		sigs: signals,
+		cnts: make(map[os.Signal]*Counters),
+		ignr: opts.Ignore,
+		evts: newevents(opts.Events),
+		pidf: opts.Pidf,
 		sigf: trapf,
 		fwdf: fwrdf,
 		done: ctx,
@@ -101,6 +188,9 @@ func (h *Signalh) Start() error {
 
 	h.strt.Do(func() {
 		h.init.Set()
+		for _, q := range h.sigs {
+			go h.work(q)
+		}
 		go h.handle()
 	})
 
@@ -110,11 +200,18 @@ func (h *Signalh) Start() error {
 	return nil
 }
 
+// depth - the receive buffer, the runtime drops signals when it is full so
+// the receive loop never waits on a trap
+const depth = 128
+
 func (h *Signalh) handle() {
-	c := make(chan os.Signal, 1)
+	c := make(chan os.Signal, depth)
 	signal.Notify(c)
 	// Ignore SIGURG as it is used for goroutine preemption
 	signal.Reset(syscall.SIGURG)
+	if len(h.ignr) > 0 {
+		signal.Ignore(h.ignr...)
+	}
 
 	for {
 		select {
@@ -122,16 +219,7 @@ func (h *Signalh) handle() {
 			if h.logr.IsTrace() {
 				h.logr.Tracef("received signal %v", sig)
 			}
-			_, trap := h.sigs[sig]
-			if trap {
-				if e := h.sigf(sig); e != nil {
-					h.logr.Error(e.Error())
-				}
-			} else {
-				if e := h.fwdf(sig); e != nil {
-					h.logr.Error(e.Error())
-				}
-			}
+			h.dispatch(sig)
 		case <-h.done.Done():
 			h.logr.Trace("handler exiting")
 			return
@@ -139,6 +227,22 @@ func (h *Signalh) handle() {
 	}
 }
 
+// dispatch - forwards the signal and queues its trap as the trap's mode
+// asks, untrapped signals are forwarded
+func (h *Signalh) dispatch(sig os.Signal) {
+	h.count(sig, func(c *Counters) { c.Received++ })
+	q, trap := h.sigs[sig]
+	if !trap {
+		h.Forward(sig)
+		return
+	}
+
+	if q.mode == ModeForwardRun {
+		h.Forward(sig)
+	}
+	h.push(q, sig)
+}
+
 var signal2name = map[syscall.Signal]string{
 	syscall.SIGHUP:    "SIGHUP",
 	syscall.SIGINT:    "SIGINT",
@@ -155,6 +259,7 @@ var signal2name = map[syscall.Signal]string{
 	syscall.SIGPIPE:   "SIGPIPE",
 	syscall.SIGALRM:   "SIGALRM",
 	syscall.SIGTERM:   "SIGTERM",
+	syscall.SIGSTKFLT: "SIGSTKFLT",
 	syscall.SIGCHLD:   "SIGCHLD",
 	syscall.SIGCONT:   "SIGCONT",
 	syscall.SIGSTOP:   "SIGSTOP",
@@ -167,22 +272,61 @@ var signal2name = map[syscall.Signal]string{
 	syscall.SIGVTALRM: "SIGVTALRM",
 	syscall.SIGPROF:   "SIGPROF",
 	syscall.SIGWINCH:  "SIGWINCH",
+	syscall.SIGIO:     "SIGIO",
+	syscall.SIGPWR:    "SIGPWR",
 	syscall.SIGSYS:    "SIGSYS",
 }
 
+// aliases - other names for the same signal, only used for parsing
+var aliases = map[string]syscall.Signal{
+	"SIGIOT":  syscall.SIGABRT,
+	"SIGPOLL": syscall.SIGIO,
+	"SIGCLD":  syscall.SIGCHLD,
+}
+
+// realtime signals, glibc reserves the first two for threading so
+// SIGRTMIN is 34 as reported by kill -l
+const (
+	SIGRTMIN = syscall.Signal(34)
+	SIGRTMAX = syscall.Signal(64)
+)
+
 var name2signal map[string]os.Signal
 
-// ToSignal string to Signal
+// ToSignal string to Signal, accepts names with or without the SIG prefix
+// in any case, SIGRTMIN+n, SIGRTMAX-n and signal numbers
 func ToSignal(name string) (os.Signal, error) {
-	if sig, ok := name2signal[name]; ok {
+	n := strings.ToUpper(strings.TrimSpace(name))
+	if num, e := strconv.Atoi(n); e == nil {
+		s := syscall.Signal(num)
+		if _, ok := signal2name[s]; ok || isrealtime(s) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("invalid signal number: %s", name)
+	}
+
+	if !strings.HasPrefix(n, "SIG") {
+		n = "SIG" + n
+	}
+	if sig, ok := name2signal[n]; ok {
 		return sig, nil
 	}
+	if s, ok := realtime(n); ok {
+		return s, nil
+	}
 	return nil, fmt.Errorf("invalid signal name: %s", name)
 }
 
 // SignalToName -
 func SignalToName(s os.Signal) string {
-	return signal2name[s.(syscall.Signal)]
+	ss := s.(syscall.Signal)
+	if name, ok := signal2name[ss]; ok {
+		return name
+	}
+	if isrealtime(ss) {
+		return rtname(ss)
+	}
+	return ""
 }
 
 // SignalToNumber - only works for *nix
@@ -190,9 +334,69 @@ func SignalToNumber(s os.Signal) int {
 	return int(s.(syscall.Signal))
 }
 
+// Signals - every supported signal, ordered by number
+func Signals() []os.Signal {
+	signals := make([]os.Signal, 0, len(signal2name)+int(SIGRTMAX-SIGRTMIN)+1)
+	for s := syscall.Signal(1); s <= SIGRTMAX; s++ {
+		if _, ok := signal2name[s]; ok || isrealtime(s) {
+			signals = append(signals, s)
+		}
+	}
+	return signals
+}
+
+func isrealtime(s syscall.Signal) bool {
+	return s >= SIGRTMIN && s <= SIGRTMAX
+}
+
+// rtname - names a realtime signal relative to the nearest end of the
+// range, as kill -l does
+func rtname(s syscall.Signal) string {
+	mid := SIGRTMIN + (SIGRTMAX-SIGRTMIN)/2
+	switch {
+	case s == SIGRTMIN:
+		return "SIGRTMIN"
+	case s == SIGRTMAX:
+		return "SIGRTMAX"
+	case s <= mid:
+		return fmt.Sprintf("SIGRTMIN+%d", s-SIGRTMIN)
+	default:
+		return fmt.Sprintf("SIGRTMAX-%d", SIGRTMAX-s)
+	}
+}
+
+// realtime - parses SIGRTMIN, SIGRTMAX, SIGRTMIN+n and SIGRTMAX-n
+func realtime(name string) (syscall.Signal, bool) {
+	base, sign := SIGRTMIN, "+"
+	switch {
+	case strings.HasPrefix(name, "SIGRTMIN"):
+	case strings.HasPrefix(name, "SIGRTMAX"):
+		base, sign = SIGRTMAX, "-"
+	default:
+		return 0, false
+	}
+
+	rest := name[len("SIGRTMIN"):]
+	if len(rest) == 0 {
+		return base, true
+	}
+	if !strings.HasPrefix(rest, sign) {
+		return 0, false
+	}
+	off, e := strconv.Atoi(rest)
+	if e != nil {
+		return 0, false
+	}
+	s := base + syscall.Signal(off)
+	return s, isrealtime(s)
+}
+
 func init() {
 	name2signal = make(map[string]os.Signal)
 	for v, k := range signal2name {
 		name2signal[k] = v
 	}
+	for k, v := range aliases {
+		name2signal[k] = v
+	}
 }
@@ -19,6 +19,8 @@ limitations under the License.
 package sig
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -117,3 +119,252 @@ func TestForwardSignal(t *testing.T) {
 		info.Exit,
 		"should exit with 15")
 }
+
+func TestTrapModes(t *testing.T) {
+	calls := make(chan string, 4)
+	opts := SignalOpts{
+		Traps: []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2},
+		Modes: map[os.Signal]Mode{
+			syscall.SIGUSR1: ModeRunForward,
+			syscall.SIGUSR2: ModeForwardRun,
+		},
+		Trapf: func(sig os.Signal) error {
+			calls <- "trap"
+			return nil
+		},
+		Fwrdf: func(sig os.Signal) error {
+			if sig == syscall.SIGUSR1 || sig == syscall.SIGUSR2 {
+				calls <- "forward"
+			}
+			return nil
+		},
+	}
+
+	h := New(opts)
+	h.Start()
+	time.Sleep(time.Second)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	assert.Equal(t, "trap", <-calls)
+	assert.Equal(t, "forward", <-calls)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	assert.Equal(t, "forward", <-calls)
+	assert.Equal(t, "trap", <-calls)
+	h.Stop()
+
+	m, err := ToMode("exit-code")
+	assert.NoError(t, err)
+	assert.Equal(t, ModeExitCode, m)
+	_, err = ToMode("nope")
+	assert.Error(t, err)
+}
+
+func TestToSignal(t *testing.T) {
+	for name, want := range map[string]syscall.Signal{
+		"SIGTERM":     syscall.SIGTERM,
+		"TERM":        syscall.SIGTERM,
+		"sigterm":     syscall.SIGTERM,
+		"term":        syscall.SIGTERM,
+		"15":          syscall.SIGTERM,
+		"SIGIOT":      syscall.SIGABRT,
+		"poll":        syscall.SIGIO,
+		"SIGPWR":      syscall.SIGPWR,
+		"SIGRTMIN":    SIGRTMIN,
+		"rtmin+1":     SIGRTMIN + 1,
+		"SIGRTMAX-2":  SIGRTMAX - 2,
+		"RTMAX":       SIGRTMAX,
+		"40":          syscall.Signal(40),
+		" SIGHUP ":    syscall.SIGHUP,
+		"SIGRTMIN+30": SIGRTMAX,
+	} {
+		s, err := ToSignal(name)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, want, s, name)
+		}
+	}
+
+	for _, name := range []string{"", "SIGNOPE", "0", "65", "32", "SIGRTMIN-1", "SIGRTMAX+1", "SIGRTMIN+31", "RTMIN3"} {
+		_, err := ToSignal(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestSignals(t *testing.T) {
+	signals := Signals()
+	assert.Equal(t, 31+int(SIGRTMAX-SIGRTMIN)+1, len(signals))
+	for _, s := range signals {
+		name := SignalToName(s)
+		assert.NotEmpty(t, name)
+		r, err := ToSignal(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, s, r, name)
+	}
+	assert.Equal(t, "SIGRTMIN+15", SignalToName(syscall.Signal(49)))
+	assert.Equal(t, "SIGRTMAX-14", SignalToName(syscall.Signal(50)))
+}
+
+func TestSlowTrap(t *testing.T) {
+	release := make(chan struct{})
+	forwarded := make(chan os.Signal, 1)
+	opts := SignalOpts{
+		Traps: []os.Signal{syscall.SIGUSR1, syscall.SIGHUP},
+		Rules: map[os.Signal]Rule{syscall.SIGHUP: RuleQueue},
+		Depth: 1,
+		Trapf: func(sig os.Signal) error {
+			<-release
+			return nil
+		},
+		Fwrdf: func(sig os.Signal) error {
+			if sig == syscall.SIGUSR2 {
+				forwarded <- sig
+			}
+			return nil
+		},
+	}
+
+	h := New(opts)
+	h.Start()
+	time.Sleep(time.Second)
+
+	// one running, one pending, the rest coalesced or dropped
+	for n := 0; n < 4; n++ {
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// forwarding is not blocked by the running traps
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	select {
+	case <-forwarded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGUSR2 was not forwarded while a trap was running")
+	}
+
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+	h.Stop()
+
+	stats := h.Stats()
+	usr1 := stats[syscall.SIGUSR1]
+	assert.Equal(t, 4, usr1.Received)
+	assert.Equal(t, 2, usr1.Trapped)
+	assert.Equal(t, 2, usr1.Coalesced)
+	assert.Equal(t, 0, usr1.Dropped)
+
+	hup := stats[syscall.SIGHUP]
+	assert.Equal(t, 4, hup.Received)
+	assert.Equal(t, 2, hup.Trapped)
+	assert.Equal(t, 2, hup.Dropped)
+	assert.Equal(t, 1, stats[syscall.SIGUSR2].Forwarded)
+}
+
+func TestTrapTimeout(t *testing.T) {
+	opts := SignalOpts{
+		Traps:    []os.Signal{syscall.SIGUSR1},
+		Timeouts: map[os.Signal]time.Duration{syscall.SIGUSR1: 200 * time.Millisecond},
+		Trapc: func(ctx context.Context, sig os.Signal) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	h := New(opts)
+	h.Start()
+	time.Sleep(time.Second)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	time.Sleep(time.Second)
+	h.Stop()
+
+	c := h.Stats()[syscall.SIGUSR1]
+	assert.Equal(t, 1, c.Trapped)
+	assert.Equal(t, 1, c.TimedOut)
+}
+
+func TestTrapRules(t *testing.T) {
+	runs := make(chan string, 8)
+	opts := SignalOpts{
+		Traps: []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2},
+		Rules: map[os.Signal]Rule{
+			syscall.SIGUSR1: RuleSkip,
+			syscall.SIGUSR2: RuleRestart,
+		},
+		Trapc: func(ctx context.Context, sig os.Signal) error {
+			select {
+			case <-ctx.Done():
+				runs <- "canceled"
+			case <-time.After(500 * time.Millisecond):
+				runs <- "done"
+			}
+			return nil
+		},
+	}
+
+	h := New(opts)
+	h.Start()
+	time.Sleep(time.Second)
+
+	for _, s := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2} {
+		syscall.Kill(os.Getpid(), s)
+		time.Sleep(100 * time.Millisecond)
+		syscall.Kill(os.Getpid(), s)
+		time.Sleep(time.Second)
+	}
+	h.Stop()
+	close(runs)
+
+	var got []string
+	for r := range runs {
+		got = append(got, r)
+	}
+	// SIGUSR1 skips the second delivery, SIGUSR2 cancels the first run
+	assert.Equal(t, []string{"done", "canceled", "done"}, got)
+
+	stats := h.Stats()
+	assert.Equal(t, 1, stats[syscall.SIGUSR1].Skipped)
+	assert.Equal(t, 1, stats[syscall.SIGUSR1].Trapped)
+	assert.Equal(t, 1, stats[syscall.SIGUSR2].Restarted)
+	assert.Equal(t, 2, stats[syscall.SIGUSR2].Trapped)
+}
+
+func TestEvents(t *testing.T) {
+	ev := newevents(3)
+	assert.Empty(t, ev.list())
+	for n := 1; n <= 5; n++ {
+		ev.add(Event{Pid: n})
+	}
+	var pids []int
+	for _, e := range ev.list() {
+		pids = append(pids, e.Pid)
+	}
+	assert.Equal(t, []int{3, 4, 5}, pids, "oldest first, the first two dropped")
+
+	opts := SignalOpts{
+		Traps: []os.Signal{syscall.SIGUSR1},
+		Trapf: func(sig os.Signal) error {
+			return fmt.Errorf("trap failed")
+		},
+		Fwrdf: func(sig os.Signal) error { return nil },
+		Pidf:  func() int { return 42 },
+	}
+	h := New(opts)
+	h.Start()
+	time.Sleep(time.Second)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	time.Sleep(200 * time.Millisecond)
+	h.Stop()
+
+	got := map[string]Event{}
+	for _, e := range h.Events() {
+		got[SignalToName(e.Signal)] = e
+	}
+	assert.Equal(t, ActionTrap, got["SIGUSR1"].Action)
+	assert.Equal(t, "trap failed", got["SIGUSR1"].Result)
+	assert.Equal(t, 42, got["SIGUSR1"].Pid)
+	assert.Equal(t, ActionForward, got["SIGUSR2"].Action)
+	assert.Equal(t, ResultOK, got["SIGUSR2"].Result)
+}
@@ -0,0 +1,8 @@
+#!/bin/bash
+pid=
+echo "daemon.sh running as child of PID $$"
+# a daemon in its own session that ignores SIGTERM
+setsid bash -c 'trap "" SIGTERM; while true; do sleep 1; done' &
+trap 'echo "trapped SIGTERM for $pid"; exit 15' SIGTERM
+sleep 10000 & pid=$!
+wait
@@ -0,0 +1,3 @@
+#!/bin/sh
+echo "draining"
+exit 2
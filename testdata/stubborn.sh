@@ -0,0 +1,7 @@
+#!/bin/bash
+echo "stubborn.sh running as child of PID $$, ignoring SIGTERM"
+trap '' SIGTERM
+while true
+do
+    sleep 1
+done
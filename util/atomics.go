@@ -118,3 +118,24 @@ func (i *AtomicInt) Swap(value int) int {
 func (i *AtomicInt) Clear() {
 	i.Set(0)
 }
+
+// AtomicInt64 - Race condition free primative wrapper, unlike AtomicInt
+// it keeps all 64 bits on 32 bit platforms
+type AtomicInt64 struct {
+	value int64
+}
+
+// Get - returns the underlying primative
+func (i *AtomicInt64) Get() int64 {
+	return atomic.LoadInt64(&(i.value))
+}
+
+// Set - sets the underlying primative
+func (i *AtomicInt64) Set(value int64) {
+	atomic.StoreInt64(&(i.value), value)
+}
+
+// Clear - resets the underlying primative to its unitialized default value
+func (i *AtomicInt64) Clear() {
+	i.Set(0)
+}
@@ -57,3 +57,12 @@ func TestAtomicInt(t *testing.T) {
 	assert.Equal(t, i.Swap(val), zero, "should be equal")
 	assert.Equal(t, i.Get(), val, "should be equal")
 }
+
+func TestAtomicInt64(t *testing.T) {
+	val := int64(1) << 40
+	i := AtomicInt64{}
+	i.Set(val)
+	assert.Equal(t, i.Get(), val, "should be equal")
+	i.Clear()
+	assert.Equal(t, i.Get(), int64(0), "should be equal")
+}